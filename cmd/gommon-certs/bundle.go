@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/burgesQ/gommon/mtls"
+	"gopkg.in/yaml.v3"
+)
+
+func bundle(args []string, stdout io.Writer) error {
+	var (
+		cfg           mtls.Config
+		level, format string
+		fs            = newFlagSet("bundle")
+	)
+
+	fs.StringVar(&cfg.Cert, "cert", "", "certificate source (required without --pkcs12)")
+	fs.StringVar(&cfg.Key, "key", "", "private key source (required without --pkcs12)")
+	fs.StringVar(&cfg.PKCS12, "pkcs12", "", "PKCS #12 bundle source, replacing --cert and --key")
+	fs.StringVar(&cfg.KeyPassphrase, "key-passphrase", "", "passphrase source of the key or of the PKCS #12 bundle")
+	fs.StringVar(&cfg.Ca, "ca", "", "CA source (a file or a directory)")
+	fs.BoolVar(&cfg.SystemRoots, "system-roots", false, "trust the system pool along the CA")
+	fs.StringVar(&level, "level", "", "client authentication level (ie. hard)")
+	fs.BoolVar(&cfg.Insecure, "insecure", false, "disable the peer verification")
+	fs.StringVar(&cfg.ServerName, "server-name", "", "server name (client config)")
+	fs.StringVar(&format, "format", "json", "output format: json or yaml")
+
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if (cfg.Cert == "" || cfg.Key == "") && cfg.PKCS12 == "" {
+		return fmt.Errorf("%w: --cert and --key (or --pkcs12) are required", ErrUsage)
+	}
+
+	if level != "" {
+		if err := cfg.Level.Set(level); err != nil {
+			return fmt.Errorf("%w: %w", ErrUsage, err)
+		}
+	}
+
+	// ensure the material is usable by the mtls package
+	if _, err := mtls.GetTLSCfg(cfg); err != nil {
+		return fmt.Errorf("loading the material: %w", err)
+	}
+
+	hash, err := cfg.ComputeHash()
+	if err != nil {
+		return err
+	}
+
+	cfg.Hash = hash
+
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling the config: %w", err)
+	}
+
+	switch format {
+	case "json":
+	case "yaml":
+		var m map[string]any
+		if err := json.Unmarshal(out, &m); err != nil {
+			return fmt.Errorf("marshaling the config: %w", err)
+		}
+
+		if out, err = yaml.Marshal(m); err != nil {
+			return fmt.Errorf("marshaling the config: %w", err)
+		}
+	default:
+		return fmt.Errorf("%w: invalid format %q", ErrUsage, format)
+	}
+
+	_, err = fmt.Fprintln(stdout, string(out))
+
+	return err //nolint:wrapcheck
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/burgesQ/gommon/mtls"
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestBundle(t *testing.T) {
+	requirer := require.New(t)
+
+	d := t.TempDir()
+	t.Setenv("TEST_P12_PASSWORD", "p12")
+
+	ca, e := generate.NewCA(generate.WithCommonName("root"))
+	requirer.Nil(e)
+
+	server, e := ca.Issue(generate.WithCommonName("server"), generate.WithDNSNames("localhost"))
+	requirer.Nil(e)
+
+	requirer.Nil(ca.WriteFiles(d, "ca"))
+	requirer.Nil(server.WriteFiles(d, "server"))
+	requirer.Nil(server.WritePKCS12File(d, "server", "p12", false))
+
+	var (
+		cert = filepath.Join(d, "server.crt")
+		key  = filepath.Join(d, "server.key")
+	)
+
+	t.Log("yaml")
+	{
+		out, e := runCmd(t, "bundle", "--cert", cert, "--key", key,
+			"--ca", filepath.Join(d, "ca.crt"), "--level", "try", "--format", "yaml")
+		requirer.Nil(e)
+
+		var m map[string]any
+
+		requirer.Nil(yaml.Unmarshal([]byte(out), &m))
+		requirer.Equal(cert, m["cert"])
+		requirer.Equal("try", m["level"])
+	}
+
+	t.Log("pkcs12")
+	{
+		out, e := runCmd(t, "bundle", "--pkcs12", filepath.Join(d, "server.p12"),
+			"--key-passphrase", "env:TEST_P12_PASSWORD")
+		requirer.Nil(e)
+		requirer.Contains(out, `"pkcs12": "`+filepath.Join(d, "server.p12")+`"`)
+
+		_, e = runCmd(t, "bundle", "--pkcs12", filepath.Join(d, "server.p12"))
+		requirer.True(errors.Is(e, mtls.ErrPKCS12), "missing password")
+	}
+
+	t.Log("invalid bundles")
+	{
+		for _, args := range [][]string{
+			{"--cert", cert},
+			{"--cert", cert, "--key", key, "--level", "sometimes"},
+			{"--cert", cert, "--key", key, "--format", "toml"},
+		} {
+			_, e := runCmd(t, append([]string{"bundle"}, args...)...)
+			requirer.True(errors.Is(e, ErrUsage), args)
+		}
+
+		_, e := runCmd(t, "bundle", "--cert", filepath.Join(d, "ca.crt"), "--key", key)
+		requirer.NotNil(e, "mismatching key")
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/burgesQ/gommon/mtls"
+	"github.com/burgesQ/gommon/mtls/generate"
+)
+
+// issueFlags hold the flags shared by the ca create and cert issue commands.
+type issueFlags struct {
+	dns, ips, uris, emails, org, ou stringsFlag
+	cn, algo, out, name, passphrase string
+	validity                        durationFlag
+	rsaBits                         int
+}
+
+func (f *issueFlags) register(fs *flag.FlagSet, name string) {
+	fs.StringVar(&f.cn, "cn", "", "subject common name")
+	fs.Var(&f.org, "o", "subject organization (repeatable)")
+	fs.Var(&f.ou, "ou", "subject organizational unit (repeatable)")
+	fs.StringVar(&f.algo, "algo", string(generate.DefaultKeyAlgorithm), "key algorithm: rsa, ecdsa-p256, ecdsa-p384, ed25519")
+	fs.IntVar(&f.rsaBits, "rsa-bits", generate.DefaultRSABits, "rsa key size")
+	fs.Var(&f.validity, "validity", "validity duration (ie. 8760h or 365d)")
+	fs.Var(&f.dns, "san", "DNS SAN (repeatable)")
+	fs.Var(&f.ips, "ip", "IP SAN (repeatable)")
+	fs.Var(&f.uris, "uri", "URI SAN, ie. a SPIFFE ID (repeatable)")
+	fs.Var(&f.emails, "email", "e-mail SAN (repeatable)")
+	fs.StringVar(&f.out, "out", ".", "output directory")
+	fs.StringVar(&f.name, "name", name, "output files base name")
+	fs.StringVar(&f.passphrase, "key-passphrase", "", "encrypt the written key with the passphrase of env:<NAME> or <file>")
+}
+
+func (f *issueFlags) options() ([]generate.Option, error) {
+	opts := []generate.Option{
+		generate.WithSubject(pkix.Name{CommonName: f.cn, Organization: f.org, OrganizationalUnit: f.ou}),
+		generate.WithKeyAlgorithm(generate.KeyAlgorithm(f.algo)),
+		generate.WithRSABits(f.rsaBits),
+		generate.WithDNSNames(f.dns...),
+		generate.WithURIs(f.uris...),
+		generate.WithEmails(f.emails...),
+	}
+
+	if f.validity > 0 {
+		opts = append(opts, generate.WithValidity(time.Duration(f.validity)))
+	}
+
+	for _, raw := range f.ips {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: invalid ip %q", ErrUsage, raw)
+		}
+
+		opts = append(opts, generate.WithIPAddresses(ip))
+	}
+
+	return opts, nil
+}
+
+func (f *issueFlags) write(c *generate.Certificate, stdout io.Writer) error {
+	if err := os.MkdirAll(f.out, 0o755); err != nil { //nolint:gomnd
+		return fmt.Errorf("creating the output directory: %w", err)
+	}
+
+	if f.passphrase == "" {
+		if err := c.WriteFiles(f.out, f.name); err != nil {
+			return err
+		}
+	} else {
+		pass, err := mtls.Config{KeyPassphrase: f.passphrase}.ReadPassphrase()
+		if err != nil {
+			return err
+		}
+
+		if err := c.WriteEncryptedFiles(f.out, f.name, pass); err != nil {
+			return err
+		}
+	}
+
+	base := filepath.Join(f.out, f.name)
+	fmt.Fprintf(stdout, "written %s.crt and %s.key (serial %s)\n", base, base, c.Cert.SerialNumber.Text(16))
+
+	return nil
+}
+
+// keyPEM return the PEM private key, encrypted if a passphrase is set.
+func (f *issueFlags) keyPEM(c *generate.Certificate) ([]byte, error) {
+	if f.passphrase == "" {
+		return c.KeyPEM()
+	}
+
+	pass, err := mtls.Config{KeyPassphrase: f.passphrase}.ReadPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.EncryptedKeyPEM(pass)
+}
+
+func caCreate(args []string, stdout io.Writer) error {
+	var (
+		f                                 issueFlags
+		issuerCert, issuerKey, issuerPass string
+		pathLen                           int
+		fs                                = newFlagSet("ca create")
+	)
+
+	f.register(fs, "ca")
+	fs.StringVar(&issuerCert, "issuer-cert", "", "issuer CA certificate, to create an intermediate CA")
+	fs.StringVar(&issuerKey, "issuer-key", "", "issuer CA private key, to create an intermediate CA")
+	fs.StringVar(&issuerPass, "issuer-key-passphrase", "", "passphrase of the encrypted issuer key: env:<NAME> or <file>")
+	fs.IntVar(&pathLen, "path-len", -1, "max path length constraint (negative for none)")
+
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	opts, err := f.options()
+	if err != nil {
+		return err
+	}
+
+	opts = append(opts, generate.WithMaxPathLen(pathLen))
+
+	var ca *generate.Certificate
+
+	if issuerCert == "" {
+		ca, err = generate.NewCA(opts...)
+	} else {
+		var issuer *generate.Certificate
+		if issuer, err = loadIssuer(issuerCert, issuerKey, issuerPass); err != nil {
+			return err
+		}
+
+		ca, err = issuer.NewIntermediate(opts...)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return f.write(ca, stdout)
+}
+
+func certIssue(args []string, stdout io.Writer) error {
+	var (
+		f                     issueFlags
+		caCert, caKey, caPass string
+		usage                 string
+		fs                    = newFlagSet("cert issue")
+	)
+
+	f.register(fs, "cert")
+	fs.StringVar(&caCert, "ca-cert", "", "issuer CA certificate (required)")
+	fs.StringVar(&caKey, "ca-key", "", "issuer CA private key (required)")
+	fs.StringVar(&caPass, "ca-key-passphrase", "", "passphrase of the encrypted CA key: env:<NAME> or <file>")
+	fs.StringVar(&usage, "usage", "both", "extended key usage: server, client or both")
+
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if caCert == "" || caKey == "" {
+		return fmt.Errorf("%w: --ca-cert and --ca-key are required", ErrUsage)
+	}
+
+	opts, err := f.options()
+	if err != nil {
+		return err
+	}
+
+	usageOpts, err := usageOptions(usage)
+	if err != nil {
+		return err
+	}
+
+	opts = append(opts, usageOpts...)
+
+	ca, err := loadIssuer(caCert, caKey, caPass)
+	if err != nil {
+		return err
+	}
+
+	cert, err := ca.Issue(opts...)
+	if err != nil {
+		return err
+	}
+
+	return f.write(cert, stdout)
+}
+
+func csrCreate(args []string, stdout io.Writer) error {
+	var (
+		f  issueFlags
+		fs = newFlagSet("csr create")
+	)
+
+	f.register(fs, "cert")
+
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	opts, err := f.options()
+	if err != nil {
+		return err
+	}
+
+	csr, key, err := generate.NewCSR(opts...)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.out, 0o755); err != nil { //nolint:gomnd
+		return fmt.Errorf("creating the output directory: %w", err)
+	}
+
+	keyPEM, err := f.keyPEM(&generate.Certificate{Key: key})
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Join(f.out, f.name)
+	if err := os.WriteFile(base+".key", keyPEM, 0o600); err != nil { //nolint:gomnd
+		return fmt.Errorf("writing the key file: %w", err)
+	}
+
+	if err := os.WriteFile(base+".csr", generate.CSRPEM(csr), 0o644); err != nil { //nolint:gomnd,gosec
+		return fmt.Errorf("writing the csr file: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "written %s.csr and %s.key\n", base, base)
+
+	return nil
+}
+
+func certSign(args []string, stdout io.Writer) error {
+	var (
+		caCert, caKey, caPass, usage, out string
+		validity                          durationFlag
+		policy                            generate.CSRPolicy
+		cns, ous, dns, ips, uris, emails  stringsFlag
+		fs                                = newFlagSet("cert sign")
+	)
+
+	fs.StringVar(&caCert, "ca-cert", "", "issuer CA certificate (required)")
+	fs.StringVar(&caKey, "ca-key", "", "issuer CA private key (required)")
+	fs.StringVar(&caPass, "ca-key-passphrase", "", "passphrase of the encrypted CA key: env:<NAME> or <file>")
+	fs.StringVar(&usage, "usage", "both", "extended key usage: server, client or both")
+	fs.StringVar(&out, "out", "", "output certificate file (default to the csr path with a .crt extension)")
+	fs.Var(&validity, "validity", "validity duration (ie. 8760h or 365d)")
+	fs.Var(&cns, "allow-cn", "allowed common name pattern (repeatable, default to any)")
+	fs.Var(&ous, "allow-ou", "allowed organizational unit pattern (repeatable, default to any)")
+	fs.Var(&dns, "allow-san", "allowed DNS SAN pattern, ie. *.svc.local (repeatable)")
+	fs.Var(&ips, "allow-ip", "allowed IP SAN CIDR (repeatable)")
+	fs.Var(&uris, "allow-uri", "allowed URI SAN pattern (repeatable)")
+	fs.Var(&emails, "allow-email", "allowed e-mail SAN pattern (repeatable)")
+	fs.IntVar(&policy.MinRSABits, "min-rsa-bits", generate.DefaultMinRSABits, "minimal rsa key size")
+	fs.DurationVar(&policy.MaxValidity, "max-validity", 0, "maximal validity (0 for none)")
+
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if caCert == "" || caKey == "" || fs.NArg() != 1 {
+		return fmt.Errorf("%w: --ca-cert, --ca-key and a csr file are required", ErrUsage)
+	}
+
+	policy.CommonNames, policy.OrganizationalUnits = cns, ous
+	policy.DNSNames, policy.IPRanges, policy.URIs, policy.Emails = dns, ips, uris, emails
+
+	b, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", fs.Arg(0), err)
+	}
+
+	csr, err := generate.ParseCSR(b)
+	if err != nil {
+		return err
+	}
+
+	opts, err := usageOptions(usage)
+	if err != nil {
+		return err
+	}
+
+	if validity > 0 {
+		opts = append(opts, generate.WithValidity(time.Duration(validity)))
+	}
+
+	ca, err := loadIssuer(caCert, caKey, caPass)
+	if err != nil {
+		return err
+	}
+
+	cert, err := ca.SignCSR(csr, &policy, opts...)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		out = strings.TrimSuffix(fs.Arg(0), filepath.Ext(fs.Arg(0))) + ".crt"
+	}
+
+	if err := os.WriteFile(out, cert.CertPEM(), 0o644); err != nil { //nolint:gomnd,gosec
+		return fmt.Errorf("writing the certificate file: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "written %s (serial %s)\n", out, cert.Cert.SerialNumber.Text(16))
+
+	return nil
+}
+
+// loadIssuer load the issuer CA, its key being decrypted with the
+// passphrase of the passRef source (if any).
+func loadIssuer(certPath, keyPath, passRef string) (*generate.Certificate, error) {
+	if passRef == "" {
+		return generate.LoadFiles(certPath, keyPath)
+	}
+
+	pass, err := mtls.Config{KeyPassphrase: passRef}.ReadPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	return generate.LoadFiles(certPath, keyPath, pass)
+}
+
+func usageOptions(usage string) ([]generate.Option, error) {
+	switch usage {
+	case "server":
+		return []generate.Option{generate.WithExtKeyUsage(x509.ExtKeyUsageServerAuth)}, nil
+	case "client":
+		return []generate.Option{generate.WithExtKeyUsage(x509.ExtKeyUsageClientAuth)}, nil
+	case "both":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: invalid usage %q", ErrUsage, usage)
+	}
+}
+
+func certExport(args []string, stdout io.Writer) error {
+	var (
+		cert, key, keyPass, password, out string
+		legacy                            bool
+		fs                                = newFlagSet("cert export")
+	)
+
+	fs.StringVar(&cert, "cert", "", "certificate, optionally followed by its chain (required)")
+	fs.StringVar(&key, "key", "", "private key (required)")
+	fs.StringVar(&keyPass, "key-passphrase", "", "passphrase of the encrypted key: env:<NAME> or <file>")
+	fs.StringVar(&password, "password", "", "password of the bundle: env:<NAME> or <file> (default to none)")
+	fs.StringVar(&out, "out", ".", "output directory")
+	fs.BoolVar(&legacy, "legacy", false, "use 3DES, for the older Windows and Java versions")
+
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if cert == "" || key == "" {
+		return fmt.Errorf("%w: --cert and --key are required", ErrUsage)
+	}
+
+	c, err := loadIssuer(cert, key, keyPass)
+	if err != nil {
+		return err
+	}
+
+	var pass []byte
+	if password != "" {
+		if pass, err = (mtls.Config{KeyPassphrase: password}).ReadPassphrase(); err != nil {
+			return err
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(cert), filepath.Ext(cert))
+	if err := c.WritePKCS12File(out, name, string(pass), legacy); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "written %s\n", filepath.Join(out, name+".p12"))
+
+	return nil
+}
+
+func certInspect(args []string, stdout io.Writer) error {
+	fs := newFlagSet("cert inspect")
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		return fmt.Errorf("%w: no certificate file given", ErrUsage)
+	}
+
+	for _, p := range fs.Args() {
+		certs, err := readCerts(p)
+		if err != nil {
+			return err
+		}
+
+		for i, c := range certs {
+			fmt.Fprintf(stdout, "%s [%d]\n", p, i)
+			printCert(stdout, c)
+		}
+	}
+
+	return nil
+}
+
+func certVerify(args []string, stdout io.Writer) error {
+	var (
+		ca, dns, ip string
+		usage       string
+		fs          = newFlagSet("cert verify")
+	)
+
+	fs.StringVar(&ca, "ca", "", "CA bundle (required)")
+	fs.StringVar(&dns, "dns", "", "DNS name the certificate must be valid for")
+	fs.StringVar(&ip, "ip", "", "IP address the certificate must be valid for")
+	fs.StringVar(&usage, "usage", "any", "extended key usage to verify: server, client or any")
+
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if ca == "" || fs.NArg() != 1 {
+		return fmt.Errorf("%w: --ca and a certificate file are required", ErrUsage)
+	}
+
+	roots, err := readCerts(ca)
+	if err != nil {
+		return err
+	}
+
+	certs, err := readCerts(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		DNSName:       dns,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	if ip != "" {
+		opts.DNSName = ip
+	}
+
+	switch usage {
+	case "server":
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case "client":
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case "any":
+	default:
+		return fmt.Errorf("%w: invalid usage %q", ErrUsage, usage)
+	}
+
+	for _, c := range roots {
+		opts.Roots.AddCert(c)
+	}
+
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", fs.Arg(0), err)
+	}
+
+	names := make([]string, 0, len(chains[0]))
+	for _, c := range chains[0] {
+		names = append(names, c.Subject.String())
+	}
+
+	fmt.Fprintf(stdout, "%s: OK (%s)\n", fs.Arg(0), strings.Join(names, " <- "))
+
+	return nil
+}
+
+func readCerts(p string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", p, err)
+	}
+
+	var certs []*x509.Certificate
+
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", p, err)
+		}
+
+		certs = append(certs, c)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: %w", p, generate.ErrNoCertificate)
+	}
+
+	return certs, nil
+}
+
+func printCert(w io.Writer, c *x509.Certificate) {
+	sum := sha256.Sum256(c.Raw)
+
+	fmt.Fprintf(w, "  subject:     %s\n", c.Subject)
+	fmt.Fprintf(w, "  issuer:      %s\n", c.Issuer)
+	fmt.Fprintf(w, "  serial:      %s\n", c.SerialNumber.Text(16))
+	fmt.Fprintf(w, "  not before:  %s\n", c.NotBefore)
+	fmt.Fprintf(w, "  not after:   %s\n", c.NotAfter)
+	fmt.Fprintf(w, "  key:         %s\n", c.PublicKeyAlgorithm)
+	fmt.Fprintf(w, "  is ca:       %t\n", c.IsCA)
+
+	if len(c.DNSNames) > 0 {
+		fmt.Fprintf(w, "  dns:         %s\n", strings.Join(c.DNSNames, ", "))
+	}
+
+	for _, ip := range c.IPAddresses {
+		fmt.Fprintf(w, "  ip:          %s\n", ip)
+	}
+
+	for _, u := range c.URIs {
+		fmt.Fprintf(w, "  uri:         %s\n", u)
+	}
+
+	if len(c.EmailAddresses) > 0 {
+		fmt.Fprintf(w, "  email:       %s\n", strings.Join(c.EmailAddresses, ", "))
+	}
+
+	fmt.Fprintf(w, "  sha256:      %s\n", hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCert(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		d         = t.TempDir()
+		passFile  = filepath.Join(d, "passphrase")
+		caCert    = filepath.Join(d, "ca.crt")
+		interCert = filepath.Join(d, "inter.crt")
+		interKey  = filepath.Join(d, "inter.key")
+	)
+
+	requirer.Nil(os.WriteFile(passFile, []byte("s3cr3t\n"), 0o600))
+
+	_, e := runCmd(t, "ca", "create", "--cn", "root", "--out", d, "--key-passphrase", passFile)
+	requirer.Nil(e)
+
+	t.Log("intermediate ca")
+	{
+		_, e := runCmd(t, "ca", "create", "--cn", "inter", "--out", d, "--name", "inter",
+			"--issuer-cert", caCert, "--issuer-key", filepath.Join(d, "ca.key"))
+		requirer.True(errors.Is(e, generate.ErrKeyPassphrase), "encrypted issuer key")
+
+		_, e = runCmd(t, "ca", "create", "--cn", "inter", "--out", d, "--name", "inter",
+			"--issuer-cert", caCert, "--issuer-key", filepath.Join(d, "ca.key"),
+			"--issuer-key-passphrase", "file:"+passFile, "--path-len", "0")
+		requirer.Nil(e)
+
+		out, e := runCmd(t, "cert", "inspect", interCert)
+		requirer.Nil(e)
+		requirer.Contains(out, "subject:     CN=inter")
+		requirer.Contains(out, "issuer:      CN=root")
+		requirer.Contains(out, "is ca:       true")
+	}
+
+	t.Log("issue and verify")
+	{
+		_, e := runCmd(t, "cert", "issue", "--ca-cert", interCert, "--ca-key", interKey,
+			"--cn", "client", "--uri", "spiffe://test/client", "--usage", "client", "--algo", "ed25519",
+			"--out", d, "--name", "client")
+		requirer.Nil(e)
+
+		client := filepath.Join(d, "client.crt")
+
+		out, e := runCmd(t, "cert", "verify", "--ca", caCert, "--usage", "client", client)
+		requirer.Nil(e)
+		requirer.Contains(out, "OK (CN=client <- CN=inter <- CN=root)")
+
+		_, e = runCmd(t, "cert", "verify", "--ca", caCert, "--usage", "server", client)
+		requirer.NotNil(e, "client only certificate")
+
+		_, e = runCmd(t, "cert", "verify", "--ca", caCert, "--usage", "peer", client)
+		requirer.True(errors.Is(e, ErrUsage))
+
+		out, e = runCmd(t, "cert", "inspect", client)
+		requirer.Nil(e)
+		requirer.Contains(out, "uri:         spiffe://test/client")
+		requirer.Contains(out, client+" [1]")
+
+		_, e = runCmd(t, "cert", "issue", "--ca-cert", interCert, "--ca-key", interKey,
+			"--cn", "bad", "--ip", "not-an-ip", "--out", d)
+		requirer.True(errors.Is(e, ErrUsage))
+	}
+
+	t.Log("csr create and cert sign")
+	{
+		_, e := runCmd(t, "csr", "create", "--cn", "svc", "--san", "svc.local", "--out", d, "--name", "svc")
+		requirer.Nil(e)
+
+		csr := filepath.Join(d, "svc.csr")
+
+		_, e = runCmd(t, "cert", "sign", "--ca-cert", interCert, "--ca-key", interKey,
+			"--allow-san", "*.internal", csr)
+		requirer.True(errors.Is(e, generate.ErrCSRPolicy))
+
+		_, e = runCmd(t, "cert", "sign", "--ca-cert", interCert, "--ca-key", interKey,
+			"--allow-san", "*.local", "--allow-cn", "api", csr)
+		requirer.True(errors.Is(e, generate.ErrCSRPolicy))
+
+		out, e := runCmd(t, "cert", "sign", "--ca-cert", interCert, "--ca-key", interKey,
+			"--allow-san", "*.local", "--allow-cn", "svc", "--validity", "1d", csr)
+		requirer.Nil(e)
+		requirer.Contains(out, "written "+filepath.Join(d, "svc.crt"))
+
+		out, e = runCmd(t, "cert", "verify", "--ca", interCert, "--dns", "svc.local", filepath.Join(d, "svc.crt"))
+		requirer.Nil(e)
+		requirer.Contains(out, "OK (CN=svc <- CN=inter)")
+
+		_, e = runCmd(t, "cert", "sign", "--ca-cert", interCert, "--ca-key", interKey)
+		requirer.True(errors.Is(e, ErrUsage), "missing csr")
+	}
+
+	t.Log("export")
+	{
+		t.Setenv("TEST_P12_PASSWORD", "p12")
+
+		out, e := runCmd(t, "cert", "export", "--cert", interCert, "--key", interKey,
+			"--password", "env:TEST_P12_PASSWORD", "--out", d)
+		requirer.Nil(e)
+		requirer.Contains(out, "written "+filepath.Join(d, "inter.p12"))
+
+		_, e = os.Stat(filepath.Join(d, "inter.p12"))
+		requirer.Nil(e)
+
+		_, e = runCmd(t, "cert", "export", "--cert", interCert, "--key", interKey,
+			"--password", "env:TEST_MISSING_PASSWORD", "--out", d)
+		requirer.NotNil(e)
+
+		_, e = runCmd(t, "cert", "export", "--cert", interCert)
+		requirer.True(errors.Is(e, ErrUsage))
+	}
+}
@@ -0,0 +1,135 @@
+// Command gommon-certs generate and inspect the certificates consumed by the
+// mtls package.
+//
+//	gommon-certs ca create    --cn root --out ./pki --key-passphrase env:CA_PASSPHRASE
+//	gommon-certs ca create    --cn inter --issuer-cert ./pki/ca.crt --issuer-key ./pki/ca.key --issuer-key-passphrase env:CA_PASSPHRASE --name inter
+//	gommon-certs cert issue   --ca-cert ./pki/ca.crt --ca-key ./pki/ca.key --cn api --san api.local --ip 10.0.0.1
+//	gommon-certs csr create   --cn api --san api.local --out ./api
+//	gommon-certs cert sign    --ca-cert ./pki/ca.crt --ca-key ./pki/ca.key --allow-cn api --allow-san '*.local' ./api/cert.csr
+//	gommon-certs cert inspect ./pki/api.crt
+//	gommon-certs cert verify  --ca ./pki/ca.crt --dns api.local ./pki/api.crt
+//	gommon-certs cert export  --cert ./pki/api.crt --key ./pki/api.key --password env:P12_PASSWORD --out ./pki
+//	gommon-certs bundle       --cert ./pki/api.crt --key ./pki/api.key --ca ./pki/ca.crt --level hard
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const _usage = `usage: gommon-certs <command> [flags]
+
+commands:
+  ca create      generate a root or intermediate CA
+  cert issue     issue a certificate signed by a CA
+  csr create     generate a private key and a certificate signing request
+  cert sign      sign a certificate signing request with a CA
+  cert inspect   print the content of PEM certificates
+  cert verify    verify a certificate against a CA bundle
+  cert export    export a certificate, its chain and its key as PKCS #12
+  bundle         print the mtls.Config (json / yaml) of a certificate
+`
+
+// ErrUsage error is returned on invalid command line.
+var ErrUsage = errors.New("invalid usage")
+
+type command func(args []string, stdout io.Writer) error
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "gommon-certs: %s\n", err)
+
+		if errors.Is(err, ErrUsage) {
+			fmt.Fprint(os.Stderr, _usage)
+		}
+
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	commands := map[string]command{
+		"ca create":    caCreate,
+		"cert issue":   certIssue,
+		"csr create":   csrCreate,
+		"cert sign":    certSign,
+		"cert inspect": certInspect,
+		"cert verify":  certVerify,
+		"cert export":  certExport,
+		"bundle":       bundle,
+	}
+
+	for name, cmd := range commands {
+		words := strings.Fields(name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == name {
+			return cmd(args[len(words):], stdout)
+		}
+	}
+
+	return fmt.Errorf("%w: unknown command %q", ErrUsage, strings.Join(args, " "))
+}
+
+// newFlagSet return a flag set which doesn't exit nor print on error.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	return fs
+}
+
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrUsage, fs.Name(), err)
+	}
+
+	return nil
+}
+
+// stringsFlag is a repeatable (and comma separated) string flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*f = append(*f, s)
+		}
+	}
+
+	return nil
+}
+
+// durationFlag is a time.Duration flag also accepting a number of days
+// (ie. "365d").
+type durationFlag time.Duration
+
+func (f *durationFlag) String() string { return time.Duration(*f).String() }
+
+func (f *durationFlag) Set(v string) error {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("parsing the number of days: %w", err)
+		}
+
+		*f = durationFlag(time.Duration(n) * 24 * time.Hour)
+
+		return nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("parsing the duration: %w", err)
+	}
+
+	*f = durationFlag(d)
+
+	return nil
+}
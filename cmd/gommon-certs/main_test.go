@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/burgesQ/gommon/mtls"
+	"github.com/stretchr/testify/require"
+)
+
+// runCmd run the command line and return its output.
+func runCmd(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+
+	err := run(args, &out)
+
+	return out.String(), err
+}
+
+func TestRun(t *testing.T) {
+	requirer := require.New(t)
+
+	d := t.TempDir()
+	t.Setenv("TEST_CA_PASSPHRASE", "s3cr3t")
+
+	t.Log("ca create -> cert issue -> cert verify -> bundle")
+	{
+		out, e := runCmd(t, "ca", "create", "--cn", "root", "--out", d,
+			"--key-passphrase", "env:TEST_CA_PASSPHRASE")
+		requirer.Nil(e)
+		requirer.Contains(out, "written "+filepath.Join(d, "ca")+".crt")
+
+		out, e = runCmd(t, "cert", "issue", "--ca-cert", filepath.Join(d, "ca.crt"),
+			"--ca-key", filepath.Join(d, "ca.key"), "--ca-key-passphrase", "env:TEST_CA_PASSPHRASE",
+			"--cn", "api", "--san", "api.local", "--ip", "127.0.0.1", "--usage", "server",
+			"--validity", "30d", "--out", d, "--name", "api")
+		requirer.Nil(e)
+		requirer.Contains(out, "written "+filepath.Join(d, "api")+".crt")
+
+		out, e = runCmd(t, "cert", "verify", "--ca", filepath.Join(d, "ca.crt"),
+			"--dns", "api.local", "--usage", "server", filepath.Join(d, "api.crt"))
+		requirer.Nil(e)
+		requirer.Contains(out, "OK (CN=api <- CN=root)")
+
+		out, e = runCmd(t, "bundle", "--cert", filepath.Join(d, "api.crt"),
+			"--key", filepath.Join(d, "api.key"), "--ca", filepath.Join(d, "ca.crt"), "--level", "hard")
+		requirer.Nil(e)
+
+		var cfg mtls.Config
+
+		requirer.Nil(json.Unmarshal([]byte(out), &cfg))
+		requirer.Equal(filepath.Join(d, "api.crt"), cfg.Cert)
+		requirer.Equal(mtls.RequireAndVerifyClientCert, cfg.Level)
+		requirer.NotEmpty(cfg.Hash)
+
+		_, e = mtls.GetTLSCfg(cfg)
+		requirer.Nil(e)
+	}
+
+	t.Log("invalid command lines")
+	{
+		for _, args := range [][]string{
+			nil,
+			{"ca"},
+			{"cert", "delete"},
+			{"ca", "create", "--unknown"},
+			{"cert", "issue", "--cn", "api"},
+		} {
+			_, e := runCmd(t, args...)
+			requirer.True(errors.Is(e, ErrUsage), args)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	requirer := require.New(t)
+
+	t.Log("strings flag")
+	{
+		var f stringsFlag
+
+		requirer.Nil(f.Set("a.local, b.local"))
+		requirer.Nil(f.Set("c.local"))
+		requirer.Equal(stringsFlag{"a.local", "b.local", "c.local"}, f)
+		requirer.Equal("a.local,b.local,c.local", f.String())
+	}
+
+	t.Log("duration flag")
+	{
+		var f durationFlag
+
+		requirer.Nil(f.Set("365d"))
+		requirer.Equal(365*24*time.Hour, time.Duration(f))
+
+		requirer.Nil(f.Set("90m"))
+		requirer.Equal("1h30m0s", f.String())
+
+		requirer.NotNil(f.Set("xd"))
+		requirer.NotNil(f.Set("forever"))
+	}
+}
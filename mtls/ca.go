@@ -0,0 +1,164 @@
+package mtls
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+)
+
+// CALabelSystem is the label of the chains verified by the system pool.
+const CALabelSystem = "system"
+
+type (
+	// CASource is a labeled CA source: a file, a directory of PEM files
+	// (ie. a hashed /etc/ssl/certs) or any other source (see SourceFile).
+	CASource struct {
+		// Label name the CA in the Identity, default to the source.
+		Label string `json:"label,omitempty" mapstructure:"label"`
+		// Source of the CA certificates.
+		Source string `json:"source" mapstructure:"source"`
+	}
+
+	// caBundle hold the certificates of a labeled CA source.
+	caBundle struct {
+		label string
+		certs []*x509.Certificate
+	}
+
+	// caLabels resolve the label of the CA which verified a chain.
+	caLabels struct {
+		bundles []caBundle
+		system  bool
+	}
+)
+
+// label return the label of the CA source, default to the source.
+func (s CASource) label() string {
+	if s.Label != "" {
+		return s.Label
+	}
+
+	return describeSource(s.Source)
+}
+
+// caSources return the Ca and the CAs of the config as labeled sources.
+func (cfg Config) caSources() []CASource {
+	out := make([]CASource, 0, len(cfg.CAs)+1)
+	if cfg.Ca != "" {
+		out = append(out, CASource{Source: cfg.Ca})
+	}
+
+	return append(out, cfg.CAs...)
+}
+
+// hasCA return true if the config trust some CA (Ca, CAs or the system
+// pool).
+func (cfg Config) hasCA() bool {
+	return cfg.Ca != "" || len(cfg.CAs) > 0 || cfg.SystemRoots
+}
+
+// readCA read the (optional) ca sources of the config.
+func (raw *rawMaterial) readCA(cfg Config) error {
+	raw.systemRoots = cfg.SystemRoots
+
+	for _, src := range cfg.caSources() {
+		b, err := cfg.load(src.Source)
+		if err != nil {
+			return fmt.Errorf("cannot load ca cert %q in pool: %w", describeSource(src.Source), err)
+		}
+
+		if n := len(raw.ca); n > 0 && raw.ca[n-1] != '\n' {
+			raw.ca = append(raw.ca, '\n')
+		}
+
+		raw.cas = append(raw.cas, b)
+		raw.ca = append(raw.ca, b...)
+	}
+
+	return nil
+}
+
+// systemPool return a copy of the system pool.
+func systemPool() (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, fmt.Errorf("%w: loading the system pool: %w", ErrParseUserCA, err)
+	}
+
+	return pool, nil
+}
+
+// newCALabels load the CA sources of the config, its SNI entries and
+// routes.
+func newCALabels(cfg Config) (*caLabels, error) {
+	out := &caLabels{system: cfg.SystemRoots}
+	if cfg.Insecure {
+		return out, nil
+	}
+
+	sources := cfg.caSources()
+	for _, entry := range cfg.SNI {
+		sources = append(sources, CASource{Source: entry.Ca})
+	}
+
+	for _, route := range cfg.Routes {
+		sources = append(sources, CASource{Source: route.Ca})
+	}
+
+	for _, src := range sources {
+		if src.Source == "" {
+			continue
+		}
+
+		certs, err := cfg.loadCertificates(src.Source)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrParseUserCA, err)
+		}
+
+		out.bundles = append(out.bundles, caBundle{label: src.label(), certs: certs})
+	}
+
+	return out, nil
+}
+
+// of return the label of the CA anchoring the chain: the first bundle
+// holding the last certificate of the chain or its issuer, else
+// CALabelSystem if the system pool is trusted.
+func (l *caLabels) of(chain []*x509.Certificate) string {
+	if l == nil || len(chain) == 0 {
+		return ""
+	}
+
+	last := chain[len(chain)-1]
+
+	for _, b := range l.bundles {
+		for _, c := range b.certs {
+			if c.Equal(last) ||
+				(bytes.Equal(c.RawSubject, last.RawIssuer) && last.CheckSignatureFrom(c) == nil) {
+				return b.label
+			}
+		}
+	}
+
+	if l.system {
+		return CALabelSystem
+	}
+
+	return ""
+}
+
+// caAttrs return the labels of the CA sources as slog attributes.
+func (cfg Config) caAttrs() []any {
+	var out []any
+
+	for _, src := range cfg.CAs {
+		out = append(out, slog.String("CA "+src.label(), describeSource(src.Source)))
+	}
+
+	if cfg.SystemRoots {
+		out = append(out, slog.Bool("system roots", true))
+	}
+
+	return out
+}
@@ -0,0 +1,101 @@
+package mtls
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCAs(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki            = newTestPKI(t)
+		server, client = pki.configs(t)
+		d              = t.TempDir()
+		partnerDir     = filepath.Join(d, "certs")
+		partnerFile    = filepath.Join(partnerDir, "5d30f3c5.0")
+	)
+
+	partner, e := generate.NewCA(generate.WithCommonName("partner-ca"))
+	requirer.Nil(e)
+
+	partnerClient, e := partner.Issue(generate.WithCommonName("partner-client"))
+	requirer.Nil(e)
+
+	requirer.Nil(os.Mkdir(partnerDir, 0o700))
+	requirer.Nil(os.WriteFile(partnerFile, partner.CertPEM(), 0o600))
+	requirer.Nil(os.WriteFile(filepath.Join(partnerDir, "README"), []byte("skipped"), 0o600))
+
+	server.Ca = ""
+	server.CAs = []CASource{{Label: "internal", Source: string(pki.ca.CertPEM())}, {Source: partnerDir}}
+
+	t.Log("directory source")
+	{
+		b, e := DefaultLoader().Load(partnerDir)
+		requirer.Nil(e)
+		requirer.Equal(string(partner.CertPEM()), string(b))
+
+		_, e = DefaultLoader().Load(t.TempDir())
+		requirer.True(errors.Is(e, ErrEmptySource))
+	}
+
+	t.Log("labeled CAs")
+	{
+		requirer.Nil(server.Validate())
+
+		srv := startIdentityServer(t, server)
+
+		status, id := fetchIdentity(t, srv, client)
+		requirer.Equal(200, status)
+		requirer.Equal("client", id.CommonName)
+		requirer.Equal("internal", id.CA)
+
+		other := client
+		other.Cert, other.Key = pemOf(t, partnerClient)
+
+		status, id = fetchIdentity(t, srv, other)
+		requirer.Equal(200, status)
+		requirer.Equal("partner-client", id.CommonName)
+		requirer.Equal(partnerDir, id.CA)
+	}
+
+	t.Log("system roots")
+	{
+		cfg := Config{SystemRoots: true}
+		requirer.False(cfg.Empty())
+
+		c, e := GetClientTLSCfg(cfg)
+		requirer.Nil(e)
+		requirer.NotNil(c.RootCAs)
+
+		cfg.Level = RequireAndVerifyClientCert
+		requirer.Nil(cfg.Validate(), "the system pool is a CA")
+
+		cfg = server
+		cfg.CAs, cfg.SystemRoots = server.CAs[:1], true
+		requirer.Nil(cfg.Validate())
+		requirer.Equal([]string{"cas", "system_roots"}, cfg.Diff(server))
+	}
+
+	t.Log("invalid CA source")
+	{
+		cfg := server
+		cfg.CAs = append([]CASource{}, server.CAs...)
+		cfg.CAs[1].Source = filepath.Join(d, "missing")
+
+		_, e := GetTLSCfg(cfg)
+		requirer.NotNil(e)
+
+		var fe FieldError
+
+		e = cfg.Validate()
+		requirer.True(errors.Is(e, ErrParseUserCA))
+		requirer.True(errors.As(e, &fe))
+		requirer.Equal("cas[1].source", fe.Field)
+	}
+}
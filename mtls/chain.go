@@ -0,0 +1,242 @@
+package mtls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ErrChain error is returned for a broken certificate chain.
+var ErrChain = errors.New("broken certificate chain")
+
+// readIntermediates read the (optional) intermediates source of the config.
+func (raw *rawMaterial) readIntermediates(cfg Config) (err error) {
+	if cfg.Intermediates == "" {
+		return nil
+	}
+
+	if raw.intermediates, err = cfg.load(cfg.Intermediates); err != nil {
+		return fmt.Errorf("cannot load intermediates [%s]: %w", describeSource(cfg.Intermediates), err)
+	}
+
+	return nil
+}
+
+// parseCertificates parse the PEM certificates of b.
+func parseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var out []*x509.Certificate
+
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, c)
+	}
+
+	return out, nil
+}
+
+// splitCABundle split a CA bundle in its roots (the self-signed
+// certificates) and its intermediates. A bundle without self-signed
+// certificate is made of roots only (ie. trust anchored at an intermediate).
+func splitCABundle(certs []*x509.Certificate) (roots, intermediates []*x509.Certificate) {
+	for _, c := range certs {
+		if isSelfSigned(c) {
+			roots = append(roots, c)
+		} else {
+			intermediates = append(intermediates, c)
+		}
+	}
+
+	if len(roots) == 0 {
+		return intermediates, nil
+	}
+
+	return roots, intermediates
+}
+
+// caPools return the roots pool (merged with the system one if enabled)
+// and the intermediates of the CA and of the intermediates bundle. The
+// roots pool is nil if no CA is configured.
+func (raw rawMaterial) caPools() (roots *x509.CertPool, inter []*x509.Certificate, err error) {
+	if raw.systemRoots {
+		if roots, err = systemPool(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// each source is split on its own: a source without self-signed
+	// certificate is trusted as roots
+	for _, b := range raw.cas {
+		certs, err := parseCertificates(b)
+		if err != nil || len(certs) == 0 {
+			return nil, nil, ErrParseUserCA
+		}
+
+		rootCerts, caInter := splitCABundle(certs)
+		if inter = append(inter, caInter...); roots == nil {
+			roots = x509.NewCertPool()
+		}
+
+		for _, c := range rootCerts {
+			roots.AddCert(c)
+		}
+	}
+
+	bundle, err := parseCertificates(raw.intermediates)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: parsing the intermediates: %w", ErrChain, err)
+	}
+
+	return roots, append(inter, bundle...), nil
+}
+
+// completeChain order the certificate chain from its leaf (each
+// certificate issued by the next one) and append the missing intermediates
+// found in the intermediates bundle.
+func (raw rawMaterial) completeChain(cert *tls.Certificate) error {
+	presented := make([]*x509.Certificate, 0, len(cert.Certificate))
+
+	for i, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("%w: parsing certificate %d: %w", ErrChain, i, err)
+		}
+
+		presented = append(presented, c)
+	}
+
+	chain, err := orderChain(presented)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := parseCertificates(raw.intermediates)
+	if err != nil {
+		return fmt.Errorf("%w: parsing the intermediates: %w", ErrChain, err)
+	}
+
+	// each intermediate is appended at most once
+	for range bundle {
+		last := chain[len(chain)-1]
+		if isSelfSigned(last) {
+			break
+		}
+
+		issuer := findIssuerIn(last, bundle)
+		if issuer == nil {
+			break
+		}
+
+		chain = append(chain, issuer)
+	}
+
+	cert.Certificate = rawCertificates(chain)
+
+	return nil
+}
+
+// orderChain rebuild the chain from its leaf (the first certificate), each
+// certificate being followed by its issuer. The certificates not part of
+// the chain are rejected.
+func orderChain(certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	var (
+		chain = []*x509.Certificate{certs[0]}
+		rest  = slices.Clone(certs[1:])
+	)
+
+	for len(rest) > 0 {
+		last := chain[len(chain)-1]
+		if isSelfSigned(last) {
+			break
+		}
+
+		issuer := findIssuerIn(last, rest)
+		if issuer == nil {
+			break
+		}
+
+		chain = append(chain, issuer)
+		rest = slices.DeleteFunc(rest, func(c *x509.Certificate) bool { return c == issuer })
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: certificate [%s] is not part of the chain of [%s]",
+			ErrChain, rest[0].Subject, certs[0].Subject)
+	}
+
+	return chain, nil
+}
+
+// findIssuerIn return the certificate of candidates which issued c, nil
+// if none did.
+func findIssuerIn(c *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if bytes.Equal(candidate.RawSubject, c.RawIssuer) && c.CheckSignatureFrom(candidate) == nil &&
+			!candidate.Equal(c) {
+			return candidate
+		}
+	}
+
+	return nil
+}
+
+// verifyChain verify the peer certificates against the roots, using the
+// presented intermediates and the configured ones.
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool, intermediates []*x509.Certificate,
+	opts x509.VerifyOptions,
+) ([][]*x509.Certificate, error) {
+	if len(rawCerts) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+
+	for _, der := range rawCerts {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: parsing the peer certificate: %w", ErrChain, err)
+		}
+
+		certs = append(certs, c)
+	}
+
+	opts.Roots, opts.CurrentTime, opts.Intermediates = roots, time.Now(), x509.NewCertPool()
+
+	for _, c := range slices.Concat(certs[1:], intermediates) {
+		opts.Intermediates.AddCert(c)
+	}
+
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrChain, err)
+	}
+
+	return chains, nil
+}
+
+// rawCertificates return the DER content of the certificates.
+func rawCertificates(certs []*x509.Certificate) [][]byte {
+	out := make([][]byte, 0, len(certs))
+	for _, c := range certs {
+		out = append(out, c.Raw)
+	}
+
+	return out
+}
+
+// isSelfSigned return true if the certificate is its own issuer.
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil
+}
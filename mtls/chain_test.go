@@ -0,0 +1,222 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	requirer := require.New(t)
+
+	root, e := generate.NewCA(generate.WithCommonName("test-root"))
+	requirer.Nil(e)
+
+	inter, e := root.NewIntermediate(generate.WithCommonName("test-intermediate"))
+	requirer.Nil(e)
+
+	server, e := inter.Issue(generate.WithCommonName("server"), generate.WithDNSNames("localhost"),
+		generate.WithIPAddresses(net.IPv4(127, 0, 0, 1)))
+	requirer.Nil(e)
+
+	client, e := inter.Issue(generate.WithCommonName("client"))
+	requirer.Nil(e)
+
+	// certOnly return the PEM encoded certificate, without its chain
+	certOnly := func(c *generate.Certificate) string {
+		return string((&generate.Certificate{Cert: c.Cert}).CertPEM())
+	}
+
+	var (
+		rootPEM  = certOnly(root)
+		interPEM = certOnly(inter)
+		leafOnly = func(c *generate.Certificate) Config {
+			key, e := c.KeyPEM()
+			requirer.Nil(e)
+
+			return Config{Cert: certOnly(c), Key: string(key)}
+		}
+		fullChain = func(c *generate.Certificate) Config {
+			cert, key := pemOf(t, c)
+
+			return Config{Cert: cert, Key: key}
+		}
+		hard = &Policy{CN: []string{"client"}}
+	)
+
+	t.Log("presented intermediates")
+	{
+		srvCfg := fullChain(server)
+		srvCfg.Ca, srvCfg.Level, srvCfg.Policy = rootPEM, RequireAndVerifyClientCertAndSAN, hard
+		requirer.Nil(srvCfg.Validate())
+
+		cliCfg := fullChain(client)
+		cliCfg.Ca, cliCfg.ServerName = rootPEM, "localhost"
+
+		srv := startTestServer(t, srvCfg)
+
+		c, e := NewHTTPClient(cliCfg)
+		requirer.Nil(e)
+
+		body, e := get(t, c, srv.URL)
+		requirer.Nil(e)
+		requirer.Equal("HTTP/1.1 client", body)
+	}
+
+	t.Log("intermediates bundle")
+	{
+		srvCfg := leafOnly(server)
+		srvCfg.Ca, srvCfg.Intermediates = rootPEM, interPEM
+		srvCfg.Level, srvCfg.Policy = RequireAndVerifyClientCertAndSAN, hard
+		requirer.Nil(srvCfg.Validate())
+
+		tlsCfg, e := GetTLSCfg(srvCfg)
+		requirer.Nil(e)
+		requirer.Len(tlsCfg.Certificates[0].Certificate, 2, "the served chain is completed")
+
+		cliCfg := leafOnly(client)
+		cliCfg.Ca, cliCfg.ServerName = rootPEM, "localhost"
+
+		srv := startTestServer(t, srvCfg)
+
+		c, e := NewHTTPClient(cliCfg)
+		requirer.Nil(e)
+
+		body, e := get(t, c, srv.URL)
+		requirer.Nil(e)
+		requirer.Equal("HTTP/1.1 client", body)
+
+		srvCfg.Intermediates = ""
+		srv = startTestServer(t, srvCfg)
+
+		_, e = get(t, c, srv.URL)
+		requirer.NotNil(e, "the leaf only client can't be verified")
+	}
+
+	t.Log("intermediates in the CA bundle")
+	{
+		srvCfg := fullChain(server)
+		srvCfg.Ca, srvCfg.Level = rootPEM+interPEM, RequireAndVerifyClientCert
+
+		cliCfg := leafOnly(client)
+		cliCfg.Ca, cliCfg.ServerName = rootPEM, "localhost"
+
+		srv := startTestServer(t, srvCfg)
+
+		c, e := NewHTTPClient(cliCfg)
+		requirer.Nil(e)
+
+		body, e := get(t, c, srv.URL)
+		requirer.Nil(e)
+		requirer.Equal("HTTP/1.1 client", body)
+	}
+
+	t.Log("client side intermediates bundle")
+	{
+		srvCfg := leafOnly(server)
+		srvCfg.Ca = rootPEM
+
+		cliCfg := Config{Ca: rootPEM, ServerName: "localhost"}
+		srv := startTestServer(t, srvCfg)
+
+		c, e := NewHTTPClient(cliCfg)
+		requirer.Nil(e)
+
+		_, e = get(t, c, srv.URL)
+		requirer.NotNil(e, "the leaf only server can't be verified")
+
+		cliCfg.Intermediates = interPEM
+
+		c, e = NewHTTPClient(cliCfg)
+		requirer.Nil(e)
+
+		body, e := get(t, c, srv.URL)
+		requirer.Nil(e)
+		requirer.Equal("HTTP/1.1 anonymous", body)
+
+		cliCfg.ServerName = "unknown"
+
+		c, e = NewHTTPClient(cliCfg)
+		requirer.Nil(e)
+
+		_, e = get(t, c, srv.URL)
+		requirer.True(errors.Is(e, ErrChain))
+	}
+
+	t.Log("client side intermediates bundle dialing an IP")
+	{
+		other, e := inter.Issue(generate.WithCommonName("other"), generate.WithDNSNames("other.local"))
+		requirer.Nil(e)
+
+		srvCfg := leafOnly(other)
+		srvCfg.Ca = rootPEM
+		srv := startTestServer(t, srvCfg)
+
+		cliCfg := Config{Ca: rootPEM, Intermediates: interPEM}
+
+		c, e := NewHTTPClient(cliCfg)
+		requirer.Nil(e)
+
+		_, e = get(t, c, srv.URL)
+		requirer.True(errors.Is(e, ErrServerName))
+
+		cliCfg.ServerName = "127.0.0.1"
+
+		c, e = NewHTTPClient(cliCfg)
+		requirer.Nil(e)
+
+		_, e = get(t, c, srv.URL)
+		requirer.True(errors.Is(e, ErrChain))
+
+		cliCfg.ServerName = "other.local"
+
+		c, e = NewHTTPClient(cliCfg)
+		requirer.Nil(e)
+
+		_, e = get(t, c, srv.URL)
+		requirer.Nil(e)
+	}
+
+	t.Log("pkcs12 with its CA certificates reversed")
+	{
+		reversed := &generate.Certificate{
+			Cert: server.Cert, Key: server.Key, Chain: []*x509.Certificate{root.Cert, inter.Cert},
+		}
+
+		pfx, e := reversed.PKCS12("")
+		requirer.Nil(e)
+
+		cfg := Config{PKCS12: SourceBase64 + base64.StdEncoding.EncodeToString(pfx), Ca: rootPEM}
+		requirer.Nil(cfg.Validate())
+
+		tlsCfg, e := GetTLSCfg(cfg)
+		requirer.Nil(e)
+
+		served, e := tlsCfg.GetConfigForClient(&tls.ClientHelloInfo{})
+		requirer.Nil(e)
+		requirer.Equal([][]byte{server.Cert.Raw, inter.Cert.Raw, root.Cert.Raw}, served.Certificates[0].Certificate)
+	}
+
+	t.Log("broken chain")
+	{
+		cfg := leafOnly(server)
+		cfg.Cert += rootPEM
+
+		_, e := GetTLSCfg(cfg)
+		requirer.True(errors.Is(e, ErrChain))
+		requirer.True(errors.Is(cfg.Validate(), ErrChain))
+
+		cfg = leafOnly(server)
+		cfg.Intermediates = "invalid"
+
+		_, e = GetTLSCfg(cfg)
+		requirer.NotNil(e)
+		requirer.True(errors.Is(cfg.Validate(), ErrChain))
+	}
+}
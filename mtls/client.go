@@ -0,0 +1,141 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrDefaultTransport error is returned if the http.DefaultTransport has
+// been replaced by something else than an *http.Transport.
+var ErrDefaultTransport = errors.New("http.DefaultTransport isn't an *http.Transport")
+
+// ErrServerName error is returned by a client verifying the chain against
+// the Intermediates when the server name is unknown, ie. when dialing an IP
+// without setting the config ServerName.
+var ErrServerName = errors.New("no server name to verify the server certificate against")
+
+// GetClientTLSCfg return a client tls config ready for mTLS.
+// The client certificate is loaded if the Cert and Key (or the PKCS12) are
+// set, the CA (if
+// any) is trusted as root instead of the system pool. Insecure disable the
+// server certificate verification.
+// Optional support for http can be specified via the http2 variadic argument.
+func GetClientTLSCfg(cfg Config, http2 ...bool) (*tls.Config, error) {
+	var (
+		raw  rawMaterial
+		cert *tls.Certificate
+		err  error
+	)
+
+	if cfg.Cert != "" || cfg.Key != "" || cfg.PKCS12 != "" {
+		if raw, err = readMaterial(cfg); err != nil {
+			return nil, err
+		}
+
+		c, err := raw.keyPair(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		cert = &c
+	} else if !cfg.Insecure {
+		if err = raw.readCA(cfg); err != nil {
+			return nil, err
+		}
+
+		if err = raw.readIntermediates(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := cfg.checkHash(raw); err != nil {
+		return nil, err
+	}
+
+	profile, err := cfg.TLS.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := raw.certInfos()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Expiry.check(certs); err != nil {
+		return nil, err
+	}
+
+	/* #nosec */
+	out := getBaseTLSCfg(profile, cert, http2...)
+	out.ServerName = cfg.ServerName
+
+	if cfg.Insecure {
+		out.InsecureSkipVerify = true
+
+		return out, nil
+	}
+
+	roots, intermediates, err := raw.caPools()
+	if err != nil {
+		return nil, err
+	}
+
+	if out.RootCAs = roots; len(intermediates) > 0 {
+		// crypto/tls only use the presented intermediates, the chain is
+		// verified by VerifyConnection
+		out.InsecureSkipVerify = true //nolint:gosec
+		serverName := out.ServerName
+		out.VerifyConnection = func(cs tls.ConnectionState) error {
+			// the SNI is empty when dialing an IP
+			name := cs.ServerName
+			if name == "" {
+				name = serverName
+			}
+
+			if name == "" {
+				return ErrServerName
+			}
+
+			_, err := verifyChain(rawCertificates(cs.PeerCertificates), roots, intermediates,
+				x509.VerifyOptions{DNSName: name})
+
+			return err
+		}
+	}
+
+	return out, nil
+}
+
+// NewHTTPTransport return an http.Transport (cloned from the
+// http.DefaultTransport) using the client tls config.
+func NewHTTPTransport(cfg Config, http2 ...bool) (*http.Transport, error) {
+	tlsCfg, err := GetClientTLSCfg(cfg, http2...)
+	if err != nil {
+		return nil, err
+	}
+
+	def, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, ErrDefaultTransport
+	}
+
+	tr := def.Clone()
+	tr.TLSClientConfig = tlsCfg
+	tr.ForceAttemptHTTP2 = len(http2) > 0 && http2[0]
+
+	return tr, nil
+}
+
+// NewHTTPClient return an http.Client using the client tls config.
+func NewHTTPClient(cfg Config, http2 ...bool) (*http.Client, error) {
+	tr, err := NewHTTPTransport(cfg, http2...)
+	if err != nil {
+		return nil, fmt.Errorf("creating the http transport: %w", err)
+	}
+
+	return &http.Client{Transport: tr}, nil
+}
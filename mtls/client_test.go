@@ -0,0 +1,118 @@
+package mtls
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/burgesQ/gommon/webtest"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestServer start an https server answering the client certificate CN.
+func startTestServer(t *testing.T, cfg Config, http2 ...bool) *httptest.Server {
+	t.Helper()
+
+	tlsCfg, e := GetTLSCfg(cfg, http2...)
+	require.Nil(t, e)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cn := "anonymous"
+		if len(r.TLS.PeerCertificates) > 0 {
+			cn = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+
+		_, _ = w.Write([]byte(r.Proto + " " + cn))
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.TLS = tlsCfg
+	srv.EnableHTTP2 = len(http2) > 0 && http2[0]
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func get(t *testing.T, c *http.Client, url string) (string, error) {
+	t.Helper()
+
+	resp, e := c.Get(url) //nolint: noctx
+	if e != nil {
+		return "", e
+	}
+
+	return webtest.FetchBody(t, resp), nil
+}
+
+func TestClientTLSCfg(t *testing.T) {
+	requirer := require.New(t)
+
+	server, client := newTestPKI(t).configs(t)
+
+	t.Log("client config")
+	{
+		cfg, e := GetClientTLSCfg(client, true)
+		requirer.Nil(e)
+		requirer.Len(cfg.Certificates, 1)
+		requirer.NotNil(cfg.RootCAs)
+		requirer.Equal("localhost", cfg.ServerName)
+		requirer.Equal([]string{H2TLSProto}, cfg.NextProtos)
+		requirer.Equal(DefaultCipher, cfg.CipherSuites)
+		requirer.False(cfg.InsecureSkipVerify)
+	}
+
+	t.Log("mTLS round trip")
+	{
+		srv := startTestServer(t, server)
+
+		c, e := NewHTTPClient(client)
+		requirer.Nil(e)
+
+		body, e := get(t, c, srv.URL)
+		requirer.Nil(e)
+		requirer.Equal("HTTP/1.1 client", body)
+	}
+
+	t.Log("mTLS round trip over http2")
+	{
+		srv := startTestServer(t, server, true)
+
+		c, e := NewHTTPClient(client, true)
+		requirer.Nil(e)
+
+		body, e := get(t, c, srv.URL)
+		requirer.Nil(e)
+		requirer.Equal("HTTP/2.0 client", body)
+	}
+
+	t.Log("missing client certificate")
+	{
+		srv := startTestServer(t, server)
+
+		c, e := NewHTTPClient(Config{Ca: client.Ca})
+		requirer.Nil(e)
+
+		_, e = get(t, c, srv.URL)
+		requirer.NotNil(e)
+	}
+
+	t.Log("untrusted server")
+	{
+		srv := startTestServer(t, server)
+
+		c, e := NewHTTPClient(Config{Cert: client.Cert, Key: client.Key})
+		requirer.Nil(e)
+
+		_, e = get(t, c, srv.URL)
+		requirer.NotNil(e)
+
+		c, e = NewHTTPClient(Config{Cert: client.Cert, Key: client.Key, Insecure: true})
+		requirer.Nil(e)
+
+		body, e := get(t, c, srv.URL)
+		requirer.Nil(e)
+		requirer.Equal("HTTP/1.1 client", body)
+	}
+}
@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/burgesQ/gommon/mtls"
+	"github.com/mitchellh/mapstructure"
+)
+
+var _configType = reflect.TypeOf(mtls.Config{})
+
+type (
+	// DecoderOption customize the decoder of the mtls.Config maps, ie. the
+	// viper.DecoderConfigOption used to unmarshal the whole config. It
+	// default to a weakly typed decoder using the Level, duration and
+	// slice hooks.
+	DecoderOption = func(*mapstructure.DecoderConfig)
+
+	// source reference a source field of an mtls.Config.
+	source struct {
+		name string
+		ref  *string
+	}
+
+	// decodedConfig is an mtls.Config the hooks don't apply to, so the
+	// decoder options may hold them.
+	decodedConfig mtls.Config
+)
+
+// ConfigHookFunc allow an mtls.Config to be decoded by the spf13/viper
+// utility with its sources expanded, resolved against baseDir and decoded
+// (see ExpandSourcesHookFunc, ResolveSourcesHookFunc and
+// DecodeSourcesHookFunc), then validated (see ValidateConfigHookFunc).
+// The opts customize the decoding of the config maps (see DecoderOption).
+// Use as following:
+//
+//	v.Unmarshal(&cfg, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+//		cmd.StringToLevelHookFunc(),
+//		cmd.ConfigHookFunc(filepath.Dir(v.ConfigFileUsed()),
+//			func(dc *mapstructure.DecoderConfig) { dc.ErrorUnused = true }))))
+func ConfigHookFunc(baseDir string, opts ...DecoderOption) mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		ExpandSourcesHookFunc(opts...),
+		ResolveSourcesHookFunc(baseDir, opts...),
+		DecodeSourcesHookFunc(opts...),
+		ValidateConfigHookFunc(opts...))
+}
+
+// ExpandSourcesHookFunc expand the leading ~ and the environment variables
+// ($NAME or ${NAME}) of the path sources (see mtls.SourceFile) of an
+// mtls.Config.
+func ExpandSourcesHookFunc(opts ...DecoderOption) mapstructure.DecodeHookFunc {
+	return configHook(opts, func(cfg *mtls.Config) error {
+		for _, src := range append(sources(cfg), passphrase(cfg)...) {
+			mapPath(src.ref, expandPath)
+		}
+
+		return nil
+	})
+}
+
+// ResolveSourcesHookFunc resolve the relative path sources of an
+// mtls.Config against baseDir, ie. the directory of the config file.
+// It is meant to be used with the mtls.DefaultLoader.
+func ResolveSourcesHookFunc(baseDir string, opts ...DecoderOption) mapstructure.DecodeHookFunc {
+	return configHook(opts, func(cfg *mtls.Config) error {
+		if baseDir == "" {
+			return nil
+		}
+
+		for _, src := range append(sources(cfg), passphrase(cfg)...) {
+			mapPath(src.ref, func(p string) string {
+				if filepath.IsAbs(p) {
+					return p
+				}
+
+				return filepath.Join(baseDir, p)
+			})
+		}
+
+		return nil
+	})
+}
+
+// DecodeSourcesHookFunc replace the base64 sources of an mtls.Config
+// holding PEM content by the inline PEM, and unescape the inline PEM
+// sources written on a single line (ie. `-----BEGIN ...\nMII...`).
+func DecodeSourcesHookFunc(opts ...DecoderOption) mapstructure.DecodeHookFunc {
+	return configHook(opts, func(cfg *mtls.Config) error {
+		for _, src := range sources(cfg) {
+			ref := *src.ref
+
+			switch {
+			case mtls.IsInlinePEM(ref):
+				if !strings.Contains(ref, "\n") {
+					*src.ref = strings.ReplaceAll(ref, `\n`, "\n")
+				}
+
+			case strings.HasPrefix(ref, mtls.SourceBase64):
+				b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(ref, mtls.SourceBase64)))
+				if err != nil {
+					return fmt.Errorf("decoding %s: %w", src.name, err)
+				}
+
+				if mtls.IsInlinePEM(string(b)) {
+					*src.ref = string(b)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// ValidateConfigHookFunc validate the decoded mtls.Config (see
+// mtls.Config.Validate).
+func ValidateConfigHookFunc(opts ...DecoderOption) mapstructure.DecodeHookFunc {
+	return configHook(opts, func(cfg *mtls.Config) error {
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid mtls config: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// configHook return a hook applying fn to the mtls.Config being decoded,
+// either from a map (decoded with the opts) or from an mtls.Config (ie.
+// returned by a previous hook).
+func configHook(opts []DecoderOption, fn func(*mtls.Config) error) mapstructure.DecodeHookFuncValue {
+	return func(from, to reflect.Value) (interface{}, error) {
+		if !from.IsValid() {
+			return nil, nil //nolint:nilnil
+		} else if to.Type() != _configType {
+			return from.Interface(), nil
+		}
+
+		cfg, ok, err := toConfig(from, opts)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return from.Interface(), nil
+		}
+
+		if err := fn(&cfg); err != nil {
+			return nil, err
+		}
+
+		return cfg, nil
+	}
+}
+
+// toConfig return a copy of the mtls.Config, or the map decoded with the
+// opts. It return false for any other data.
+func toConfig(from reflect.Value, opts []DecoderOption) (mtls.Config, bool, error) {
+	var cfg mtls.Config
+
+	switch {
+	case from.Type() == _configType:
+		cfg = from.Interface().(mtls.Config) //nolint:forcetypeassert
+		cfg.CAs, cfg.SNI, cfg.Routes = slices.Clone(cfg.CAs), slices.Clone(cfg.SNI), slices.Clone(cfg.Routes)
+
+		if cfg.Revocation != nil {
+			r := *cfg.Revocation
+			cfg.Revocation = &r
+		}
+
+		return cfg, true, nil
+
+	case from.Kind() == reflect.Map:
+		var decoded decodedConfig
+
+		dc := &mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				StringToLevelHookFunc(),
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToSliceHookFunc(",")),
+			WeaklyTypedInput: true,
+		}
+
+		for _, opt := range opts {
+			opt(dc)
+		}
+
+		dc.Result = &decoded
+
+		dec, err := mapstructure.NewDecoder(dc)
+		if err != nil {
+			return cfg, false, err
+		}
+
+		if err := dec.Decode(from.Interface()); err != nil {
+			return cfg, false, fmt.Errorf("decoding the mtls config: %w", err)
+		}
+
+		return mtls.Config(decoded), true, nil
+
+	default:
+		return cfg, false, nil
+	}
+}
+
+// sources return the certificate, key, CA and CRL sources of the config.
+func sources(cfg *mtls.Config) []source {
+	out := []source{
+		{"cert", &cfg.Cert}, {"key", &cfg.Key}, {"pkcs12", &cfg.PKCS12},
+		{"ca", &cfg.Ca}, {"intermediates", &cfg.Intermediates},
+	}
+
+	for i := range cfg.CAs {
+		out = append(out, source{fmt.Sprintf("cas[%d].source", i), &cfg.CAs[i].Source})
+	}
+
+	for i := range cfg.SNI {
+		out = append(out,
+			source{fmt.Sprintf("sni[%d].cert", i), &cfg.SNI[i].Cert},
+			source{fmt.Sprintf("sni[%d].key", i), &cfg.SNI[i].Key},
+			source{fmt.Sprintf("sni[%d].ca", i), &cfg.SNI[i].Ca})
+	}
+
+	for i := range cfg.Routes {
+		out = append(out, source{fmt.Sprintf("routes[%d].ca", i), &cfg.Routes[i].Ca})
+	}
+
+	if cfg.Revocation != nil {
+		out = append(out, source{"revocation.crl", &cfg.Revocation.CRL})
+	}
+
+	return out
+}
+
+// passphrase return the key passphrase source, which is never PEM.
+func passphrase(cfg *mtls.Config) []source {
+	return []source{{"key_passphrase", &cfg.KeyPassphrase}}
+}
+
+// mapPath apply fn to the path of a path source (see mtls.SourceFile),
+// keeping its file: prefix.
+func mapPath(ref *string, fn func(string) string) {
+	if *ref == "" || mtls.IsInlinePEM(*ref) ||
+		strings.HasPrefix(*ref, mtls.SourceBase64) || strings.HasPrefix(*ref, mtls.SourceEnv) {
+		return
+	}
+
+	p, isFile := strings.CutPrefix(*ref, mtls.SourceFile)
+	if p = fn(p); isFile {
+		p = mtls.SourceFile + p
+	}
+
+	*ref = p
+}
+
+// expandPath expand the leading ~ and the environment variables of p.
+func expandPath(p string) string {
+	p = os.ExpandEnv(p)
+
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, p[1:])
+		}
+	}
+
+	return p
+}
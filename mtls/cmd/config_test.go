@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burgesQ/gommon/mtls"
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/mitchellh/mapstructure"
+	"github.com/stretchr/testify/require"
+)
+
+type testCfg struct {
+	TLS mtls.Config `mapstructure:"tls"`
+}
+
+func decodeCfg(t *testing.T, hook mapstructure.DecodeHookFunc, in map[string]interface{}) (testCfg, error) {
+	t.Helper()
+
+	var out testCfg
+
+	dec, e := mapstructure.NewDecoder(&mapstructure.DecoderConfig{DecodeHook: hook, Result: &out})
+	require.Nil(t, e)
+
+	return out, dec.Decode(map[string]interface{}{"tls": in})
+}
+
+func TestConfigHookFunc(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		d    = t.TempDir()
+		home = t.TempDir()
+	)
+
+	ca, e := generate.NewCA(generate.WithCommonName("test-ca"))
+	requirer.Nil(e)
+
+	server, e := ca.Issue(generate.WithCommonName("server"), generate.WithDNSNames("localhost"))
+	requirer.Nil(e)
+
+	requirer.Nil(os.Mkdir(filepath.Join(d, "pki"), 0o700))
+	requirer.Nil(server.WriteFiles(filepath.Join(d, "pki"), "server"))
+	requirer.Nil(os.WriteFile(filepath.Join(home, "ca.crt"), ca.CertPEM(), 0o600))
+
+	t.Setenv("HOME", home)
+	t.Setenv("TEST_PKI_DIR", "pki")
+
+	t.Log("expanded, resolved and validated sources")
+	{
+		out, e := decodeCfg(t, ConfigHookFunc(d), map[string]interface{}{
+			"cert":       "$TEST_PKI_DIR/server.crt",
+			"key":        "file:${TEST_PKI_DIR}/server.key",
+			"ca":         "~/ca.crt",
+			"level":      "hard",
+			"revocation": map[string]interface{}{"crl_refresh": "1m"},
+		})
+		requirer.Nil(e)
+		requirer.Equal(filepath.Join(d, "pki", "server.crt"), out.TLS.Cert)
+		requirer.Equal(mtls.SourceFile+filepath.Join(d, "pki", "server.key"), out.TLS.Key)
+		requirer.Equal(filepath.Join(home, "ca.crt"), out.TLS.Ca)
+		requirer.Equal(mtls.RequireAndVerifyClientCert, out.TLS.Level)
+		requirer.Equal(time.Minute, out.TLS.Revocation.CRLRefresh)
+	}
+
+	t.Log("inline and base64 sources")
+	{
+		_, e := decodeCfg(t, DecodeSourcesHookFunc(), map[string]interface{}{
+			"sni": []map[string]interface{}{{"server_names": []string{"localhost"}, "ca": "base64:!"}},
+		})
+		requirer.NotNil(e, "invalid base64 source")
+		requirer.Contains(e.Error(), "sni[0].ca")
+
+		out, e := decodeCfg(t, DecodeSourcesHookFunc(), map[string]interface{}{
+			"ca":   mtls.SourceBase64 + base64.StdEncoding.EncodeToString(ca.CertPEM()),
+			"cert": strings.ReplaceAll(string(server.CertPEM()), "\n", `\n`),
+			"key":  "env:TEST_KEY",
+		})
+		requirer.Nil(e)
+		requirer.Equal(string(ca.CertPEM()), out.TLS.Ca)
+		requirer.Equal(string(server.CertPEM()), out.TLS.Cert)
+		requirer.Equal("env:TEST_KEY", out.TLS.Key)
+	}
+
+	t.Log("composition and validation")
+	{
+		in := map[string]interface{}{"cert": "pki/missing.crt", "key": "pki/server.key"}
+
+		out, e := decodeCfg(t, mapstructure.ComposeDecodeHookFunc(
+			ExpandSourcesHookFunc(), ResolveSourcesHookFunc(d)), in)
+		requirer.Nil(e)
+		requirer.Equal(filepath.Join(d, "pki", "missing.crt"), out.TLS.Cert)
+
+		// mapstructure doesn't wrap the hook errors
+		_, e = decodeCfg(t, ConfigHookFunc(d), in)
+		requirer.NotNil(e)
+		requirer.Contains(e.Error(), "invalid mtls config: cert: cannot load")
+
+		_, e = decodeCfg(t, ConfigHookFunc(d), map[string]interface{}{"level": "sometimes"})
+		requirer.NotNil(e)
+		requirer.Contains(e.Error(), "non existing TLS level: sometimes")
+	}
+
+	t.Log("decoder options")
+	{
+		in := map[string]interface{}{
+			"cert": "pki/server.crt", "key": "pki/server.key", "ca": "~/ca.crt", "level": "hard", "unknown": true,
+		}
+
+		_, e := decodeCfg(t, ConfigHookFunc(d), in)
+		requirer.Nil(e)
+
+		_, e = decodeCfg(t, ConfigHookFunc(d, func(dc *mapstructure.DecoderConfig) { dc.ErrorUnused = true }), in)
+		requirer.NotNil(e)
+		requirer.Contains(e.Error(), "unknown")
+
+		// the decoder may hold the config hooks
+		delete(in, "unknown")
+
+		var hook mapstructure.DecodeHookFunc
+
+		hook = mapstructure.ComposeDecodeHookFunc(StringToLevelHookFunc(),
+			ConfigHookFunc(d, func(dc *mapstructure.DecoderConfig) { dc.DecodeHook = hook }))
+
+		out, e := decodeCfg(t, hook, in)
+		requirer.Nil(e)
+		requirer.Equal(filepath.Join(d, "pki", "server.crt"), out.TLS.Cert)
+		requirer.Equal(mtls.RequireAndVerifyClientCert, out.TLS.Level)
+	}
+}
@@ -1,29 +1,89 @@
 package mtls
 
-import "log/slog"
+import (
+	"log/slog"
+	"strings"
+	"time"
+)
 
 // Config contain the tls config passed by the config file.
 type Config struct {
-	// Hash is a unique hash of the cert + key + ca content.
+	// Hash is a unique hash of the cert + key + ca content (see
+	// ComputeHash). If set, the loaded material must match it.
 	Hash string `json:"hash" mapstructure:"hash"`
-	// Cert is the path to the TLS certificate.
+	// HashAlgorithm is the algorithm of the Hash: sha256 (default), sha384
+	// or sha512.
+	HashAlgorithm string `json:"hash_algorithm,omitempty" mapstructure:"hash_algorithm"`
+	// Cert is the source of the TLS certificate (see SourceFile).
 	Cert string `json:"cert"     mapstructure:"cert"`
-	// Key is the path to the TLS key.
+	// Key is the source of the TLS key (see SourceFile).
 	Key string `json:"key"      mapstructure:"key"`
-	// CA is the path to the TLS CA certificate.
+	// KeyPassphrase is the source of the passphrase of an encrypted PKCS #8
+	// Key or of the PKCS12 bundle: env:<NAME>, file:<path> or <path>. The
+	// legacy PEM encryption (Proc-Type: 4,ENCRYPTED) is rejected.
+	KeyPassphrase string `json:"key_passphrase,omitempty" mapstructure:"key_passphrase"`
+	// PKCS12 is the source of a PKCS #12 (PFX) bundle holding the
+	// certificate, its chain and its key, replacing the Cert and Key.
+	PKCS12 string `json:"pkcs12,omitempty" mapstructure:"pkcs12"`
+	// CA is the source of the TLS CA certificate (see SourceFile). The
+	// self-signed certificates of the bundle are the roots, the other ones
+	// intermediates.
 	Ca string `json:"ca"       mapstructure:"ca"`
+	// CAs are additional labeled CA sources, trusted along the Ca. A
+	// directory source load its PEM files (ie. /etc/ssl/certs).
+	CAs []CASource `json:"cas,omitempty" mapstructure:"cas"`
+	// SystemRoots merge the system pool with the Ca and CAs.
+	SystemRoots bool `json:"system_roots,omitempty" mapstructure:"system_roots"`
+	// Intermediates is the source of a bundle of intermediate certificates,
+	// used to verify the peer chains and to complete the served one.
+	Intermediates string `json:"intermediates,omitempty" mapstructure:"intermediates"`
 	// Level TLS authentication level.
 	Level Level `json:"level"    mapstructure:"level"`
-	// Insecure is true if insecure TLS is allowed (client).
+	// Insecure is true if insecure TLS is allowed. Server side no client
+	// certificate is requested, client side the server certificate isn't
+	// verified.
 	Insecure bool `json:"insecure"    mapstructure:"insecure"`
+	// ServerName is the name used to verify the server certificate (client).
+	// It's required to dial an IP when the Intermediates are set.
+	ServerName string `json:"server_name" mapstructure:"server_name"`
+	// Policy authorize the client certificates when the
+	// RequireAndVerifyClientCertAndSAN level is used.
+	Policy *Policy `json:"policy,omitempty" mapstructure:"policy"`
+	// Revocation enable the CRL / OCSP checks of the client certificates
+	// and the OCSP stapling of the server certificate.
+	Revocation *Revocation `json:"revocation,omitempty" mapstructure:"revocation"`
+	// SNI list the certificates served by server name, the Cert and Key
+	// being the default one.
+	SNI []SNIEntry `json:"sni,omitempty" mapstructure:"sni"`
+	// Routes override the Level, Ca and Policy by server name, local port
+	// or remote address.
+	Routes []Route `json:"routes,omitempty" mapstructure:"routes"`
+	// TLS select the TLS profile (versions, curves and ciphers), default
+	// to ProfileIntermediate.
+	TLS *TLSOptions `json:"tls,omitempty" mapstructure:"tls"`
+	// Expiry enable the monitoring of the certificates validity.
+	Expiry *Expiry `json:"expiry,omitempty" mapstructure:"expiry"`
+	// Network is the network listened on by Listen: tcp (dual-stack),
+	// tcp4, tcp6 or unix. Default to tcp4.
+	Network string `json:"network,omitempty" mapstructure:"network"`
+	// HandshakeTimeout bound the handshakes started by an observed
+	// listener (see ObserveListener). Zero leave the handshakes to the
+	// deadlines of the server reading the connections.
+	HandshakeTimeout time.Duration `json:"handshake_timeout,omitempty" mapstructure:"handshake_timeout"`
+	// Observer receive the server handshake events (see ObserveListener).
+	Observer Observer `json:"-" mapstructure:"-"`
+	// Passphrase return the passphrase of an encrypted Key or of the
+	// PKCS12 bundle, taking precedence over KeyPassphrase.
+	Passphrase func() ([]byte, error) `json:"-" mapstructure:"-"`
+	// Loader fetch the Cert, Key and Ca content. Default to DefaultLoader.
+	// Use FSLoader to read from an fs.FS (ie. embed.FS).
+	Loader Loader `json:"-" mapstructure:"-"`
 }
 
+// SameAs return true if cfg and in only differ by their Observer,
+// Passphrase and Loader (see Diff).
 func (cfg Config) SameAs(in Config) bool {
-	return cfg.Hash == in.Hash &&
-		cfg.Cert == in.Cert &&
-		cfg.Key == in.Key &&
-		cfg.Ca == in.Ca &&
-		cfg.Level == in.Level
+	return len(cfg.Diff(in)) == 0
 }
 
 // // GetCert implemte Config.
@@ -37,23 +97,47 @@ func (cfg Config) SameAs(in Config) bool {
 
 // func (cfg Config) GetLevel() Level { return cfg.Level }
 
+// load fetch the content referenced by ref via the config Loader.
+func (cfg Config) load(ref string) ([]byte, error) {
+	if cfg.Loader == nil {
+		return DefaultLoader().Load(ref)
+	}
+
+	return cfg.Loader.Load(ref)
+}
+
 // Empty implement Config.
 func (cfg Config) Empty() bool {
-	return cfg.Hash == "" && cfg.Cert == "" && cfg.Key == "" && !cfg.Insecure
+	return cfg.Hash == "" && cfg.Cert == "" && cfg.Key == "" && cfg.PKCS12 == "" && !cfg.hasCA() &&
+		cfg.Level == NoClientCert && !cfg.Insecure
 }
 
+// AsAttrs return the config as slog attributes. The material isn't loaded,
+// see CertAttrs(Reloader.Certificates()) for the validity of the served
+// certificates.
 func (cfg Config) AsAttrs() []any {
 	if cfg.Empty() {
 		return []any{}
 	}
 
-	return []any{
-		slog.String("cert", cfg.Cert),
-		slog.String("key", cfg.Key),
-		slog.String("CA", cfg.Ca),
+	attrs := []any{
+		slog.String("cert", describeSource(cfg.Cert)),
+		slog.String("key", describeSource(cfg.Key)),
+		slog.String("pkcs12", describeSource(cfg.PKCS12)),
+		slog.String("CA", describeSource(cfg.Ca)),
+		slog.String("intermediates", describeSource(cfg.Intermediates)),
 		slog.String("hash", cfg.Hash),
 
 		slog.String("level", cfg.Level.String()),
-		slog.Bool("insecure (client)", cfg.Insecure),
+		slog.Bool("insecure", cfg.Insecure),
+		slog.String("server name (client)", cfg.ServerName),
 	}
+
+	attrs = append(attrs, cfg.caAttrs()...)
+
+	for _, entry := range cfg.SNI {
+		attrs = append(attrs, slog.String("sni", strings.Join(entry.ServerNames, ",")))
+	}
+
+	return attrs
 }
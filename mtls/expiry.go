@@ -0,0 +1,224 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// DefaultExpiryWarning is the default delay before expiry from which the
+// Expiry warning hook is fired.
+const DefaultExpiryWarning = 30 * 24 * time.Hour
+
+// Gauges set through the Metrics interface, labeled by role, subject and
+// serial.
+const (
+	MetricNotBefore = "mtls_certificate_not_before_timestamp_seconds"
+	MetricNotAfter  = "mtls_certificate_not_after_timestamp_seconds"
+)
+
+// Roles of the certificates referenced by a Config.
+const (
+	CertRoleLeaf         CertRole = "leaf"
+	CertRoleIntermediate CertRole = "intermediate"
+	CertRoleCA           CertRole = "ca"
+)
+
+var (
+	// ErrCertExpired error is returned in strict mode if a certificate is
+	// expired.
+	ErrCertExpired = errors.New("certificate expired")
+	// ErrCertNotYetValid error is returned in strict mode if a certificate
+	// isn't valid yet.
+	ErrCertNotYetValid = errors.New("certificate not yet valid")
+)
+
+type (
+	// Expiry configure the monitoring of the certificates validity. The
+	// check is run each time the material is loaded, and at each Reloader
+	// poll.
+	Expiry struct {
+		// WarnBefore is the delay before expiry from which OnWarn is
+		// fired. Default to DefaultExpiryWarning.
+		WarnBefore time.Duration `json:"warn_before" mapstructure:"warn_before"`
+		// Strict refuse to load an expired or not yet valid certificate.
+		Strict bool `json:"strict" mapstructure:"strict"`
+		// OnWarn is fired for each certificate expiring within WarnBefore
+		// (or already expired). Default to a slog warning.
+		OnWarn func(CertInfo) `json:"-" mapstructure:"-"`
+		// Metrics receive the validity gauges of the certificates.
+		Metrics Metrics `json:"-" mapstructure:"-"`
+	}
+
+	// CertRole is the role of a certificate in a Config.
+	CertRole string
+
+	// CertInfo describe the validity of a loaded certificate.
+	CertInfo struct {
+		Role      CertRole
+		Subject   string
+		Serial    string
+		NotBefore time.Time
+		NotAfter  time.Time
+	}
+
+	// Metrics receive the certificates gauges, ie. backed by a prometheus
+	// GaugeVec.
+	Metrics interface {
+		SetGauge(name string, value float64, labels map[string]string)
+	}
+)
+
+// Inspect return the validity of the certificates referenced by the
+// config: the leaf, its intermediates and the CAs.
+func Inspect(cfg Config) ([]CertInfo, error) {
+	var (
+		raw rawMaterial
+		err error
+	)
+
+	if cfg.PKCS12 != "" {
+		if err := raw.readPKCS12(cfg); err != nil {
+			return nil, err
+		}
+	} else if cfg.Cert != "" {
+		if raw.cert, err = cfg.load(cfg.Cert); err != nil {
+			return nil, fmt.Errorf("cannot load cert [%s]: %w", describeSource(cfg.Cert), err)
+		}
+	}
+
+	if err := raw.readCA(cfg); err != nil {
+		return nil, err
+	}
+
+	return raw.certInfos()
+}
+
+// ExpiresIn return the delay before the certificate expiry.
+func (i CertInfo) ExpiresIn() time.Duration { return time.Until(i.NotAfter) }
+
+// LogValue implement slog.LogValuer.
+func (i CertInfo) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("role", string(i.Role)),
+		slog.String("subject", i.Subject),
+		slog.String("serial", i.Serial),
+		slog.Time("not before", i.NotBefore),
+		slog.Time("not after", i.NotAfter),
+	)
+}
+
+func newCertInfo(role CertRole, c *x509.Certificate) CertInfo {
+	return CertInfo{
+		Role:      role,
+		Subject:   c.Subject.String(),
+		Serial:    c.SerialNumber.Text(16), //nolint:gomnd
+		NotBefore: c.NotBefore,
+		NotAfter:  c.NotAfter,
+	}
+}
+
+// CertAttrs return the expiry of the leaf and CA certificates as slog
+// attributes.
+func CertAttrs(infos []CertInfo) []any {
+	var out []any
+
+	for _, i := range infos {
+		switch i.Role {
+		case CertRoleLeaf:
+			out = append(out, slog.Time("cert not after", i.NotAfter))
+		case CertRoleCA:
+			out = append(out, slog.Time("CA not after", i.NotAfter))
+		case CertRoleIntermediate:
+		}
+	}
+
+	return out
+}
+
+// certInfos parse the certificates of the material.
+func (raw rawMaterial) certInfos() ([]CertInfo, error) {
+	var out []CertInfo
+
+	for _, src := range []struct {
+		b    []byte
+		role CertRole
+	}{{raw.cert, CertRoleLeaf}, {raw.ca, CertRoleCA}} {
+		role := src.role
+
+		for block, rest := pem.Decode(src.b); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing the %s certificate: %w", role, err)
+			}
+
+			out = append(out, newCertInfo(role, c))
+
+			if role == CertRoleLeaf {
+				role = CertRoleIntermediate
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// same return true if both settings are equal, the hooks excluded.
+func (e *Expiry) same(o *Expiry) bool {
+	if e == nil || o == nil {
+		return e == o
+	}
+
+	return e.WarnBefore == o.WarnBefore && e.Strict == o.Strict
+}
+
+// check fire the warning hook and set the gauges for the certificates. In
+// strict mode an error is returned if one of them isn't valid now.
+func (e *Expiry) check(infos []CertInfo) error {
+	if e == nil {
+		return nil
+	}
+
+	var (
+		now    = time.Now()
+		warn   = e.WarnBefore
+		onWarn = e.OnWarn
+	)
+
+	if warn == 0 {
+		warn = DefaultExpiryWarning
+	}
+
+	if onWarn == nil {
+		onWarn = func(i CertInfo) {
+			slog.Warn("certificate expiring soon", slog.Any("certificate", i),
+				slog.Duration("expires in", i.ExpiresIn()))
+		}
+	}
+
+	for _, i := range infos {
+		if e.Metrics != nil {
+			labels := map[string]string{"role": string(i.Role), "subject": i.Subject, "serial": i.Serial}
+			e.Metrics.SetGauge(MetricNotBefore, float64(i.NotBefore.Unix()), labels)
+			e.Metrics.SetGauge(MetricNotAfter, float64(i.NotAfter.Unix()), labels)
+		}
+
+		switch {
+		case e.Strict && now.After(i.NotAfter):
+			return fmt.Errorf("%w: %s %q on %s", ErrCertExpired, i.Role, i.Subject, i.NotAfter)
+		case e.Strict && now.Before(i.NotBefore):
+			return fmt.Errorf("%w: %s %q until %s", ErrCertNotYetValid, i.Role, i.Subject, i.NotBefore)
+		case i.NotAfter.Sub(now) < warn:
+			onWarn(i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package mtls
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+type testMetrics map[string]float64
+
+func (m testMetrics) SetGauge(name string, value float64, labels map[string]string) {
+	m[name+"/"+labels["role"]] = value
+}
+
+func TestExpiry(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki    = newTestPKI(t)
+		server = func(c *generate.Certificate) Config {
+			cfg, _ := pki.configs(t)
+			cfg.Cert, cfg.Key = pemOf(t, c)
+
+			return cfg
+		}
+	)
+
+	expired, e := pki.ca.Issue(generate.WithCommonName("expired"),
+		generate.WithNotBefore(time.Now().Add(-48*time.Hour)), generate.WithValidity(24*time.Hour))
+	requirer.Nil(e)
+
+	future, e := pki.ca.Issue(generate.WithCommonName("future"),
+		generate.WithNotBefore(time.Now().Add(24*time.Hour)))
+	requirer.Nil(e)
+
+	t.Log("inspect")
+	{
+		infos, e := Inspect(server(pki.server))
+		requirer.Nil(e)
+		requirer.Len(infos, 2)
+		requirer.Equal(CertRoleLeaf, infos[0].Role)
+		requirer.Equal(pki.server.Cert.NotAfter, infos[0].NotAfter)
+		requirer.Equal(CertRoleCA, infos[1].Role)
+		requirer.Equal("CN=test-ca", infos[1].Subject)
+
+		requirer.NotContains(server(pki.server).AsAttrs(), slog.Time("cert not after", pki.server.Cert.NotAfter),
+			"no loading while logging")
+
+		r, e := NewReloader(server(pki.server))
+		requirer.Nil(e)
+		requirer.Equal(infos, r.Certificates())
+
+		attrs := CertAttrs(r.Certificates())
+		requirer.Contains(attrs, slog.Time("cert not after", pki.server.Cert.NotAfter))
+		requirer.Contains(attrs, slog.Time("CA not after", pki.ca.Cert.NotAfter))
+	}
+
+	t.Log("warning hook and metrics")
+	{
+		var (
+			warned  []CertInfo
+			metrics = testMetrics{}
+			cfg     = server(pki.server)
+		)
+
+		cfg.Expiry = &Expiry{WarnBefore: time.Hour, Metrics: metrics,
+			OnWarn: func(i CertInfo) { warned = append(warned, i) }}
+
+		_, e := GetTLSCfg(cfg)
+		requirer.Nil(e)
+		requirer.Empty(warned)
+		requirer.Equal(float64(pki.server.Cert.NotAfter.Unix()), metrics[MetricNotAfter+"/leaf"])
+		requirer.Equal(float64(pki.ca.Cert.NotAfter.Unix()), metrics[MetricNotAfter+"/ca"])
+
+		cfg.Expiry.WarnBefore = 400 * 24 * time.Hour
+		_, e = GetClientTLSCfg(cfg)
+		requirer.Nil(e)
+		requirer.Len(warned, 1)
+		requirer.Equal("CN=server", warned[0].Subject)
+	}
+
+	t.Log("strict mode")
+	{
+		for c, want := range map[*generate.Certificate]error{
+			expired: ErrCertExpired,
+			future:  ErrCertNotYetValid,
+		} {
+			cfg := server(c)
+			cfg.Expiry = &Expiry{OnWarn: func(CertInfo) {}}
+
+			_, e := GetTLSCfg(cfg)
+			requirer.Nil(e, "non strict mode only warn")
+
+			cfg.Expiry.Strict = true
+
+			_, e = GetTLSCfg(cfg)
+			requirer.True(errors.Is(e, want), e)
+
+			_, e = NewReloader(cfg)
+			requirer.True(errors.Is(e, want), e)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package generate
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrCSRPolicy error is returned if a CSR doesn't comply with the CSRPolicy.
+var ErrCSRPolicy = errors.New("csr rejected by policy")
+
+// CSRPolicy restrict the CSRs a CA accept to sign. SignCSR require a
+// policy.
+//
+// A nil SAN allow-list reject any SAN of its kind, while a nil
+// CommonNames or OrganizationalUnits allow-list accept any value. The
+// patterns are path.Match patterns (ie. `spiffe://example.org/*`), except
+// the DNS ones whose wildcard only match the left-most label
+// (ie. `*.svc.local` match `api.svc.local` but not `a.b.svc.local`).
+type CSRPolicy struct {
+	// CommonNames is the allow-list of the subject common name patterns.
+	CommonNames []string
+	// OrganizationalUnits is the allow-list of the subject organizational
+	// units patterns.
+	OrganizationalUnits []string
+	// DNSNames is the allow-list of the DNS SANs patterns.
+	DNSNames []string
+	// IPRanges is the allow-list of the IP SANs, as CIDRs.
+	IPRanges []string
+	// URIs is the allow-list of the URI SANs patterns.
+	URIs []string
+	// Emails is the allow-list of the e-mail SANs patterns.
+	Emails []string
+	// KeyAlgorithms is the list of accepted key algorithms (default to any).
+	KeyAlgorithms []KeyAlgorithm
+	// MinRSABits is the minimal RSA key size (default to DefaultMinRSABits).
+	MinRSABits int
+	// MaxValidity is the maximal validity of the issued certificate.
+	MaxValidity time.Duration
+}
+
+// NewCSR generate a private key and a certificate signing request for the
+// subject and SANs of the options.
+func NewCSR(opts ...Option) (*x509.CertificateRequest, crypto.Signer, error) {
+	o := newOptions(false, opts)
+
+	key, err := generateKey(o.KeyAlgorithm, o.RSABits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl, err := template(o, key.Public(), false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        tmpl.Subject,
+		DNSNames:       tmpl.DNSNames,
+		IPAddresses:    tmpl.IPAddresses,
+		URIs:           tmpl.URIs,
+		EmailAddresses: tmpl.EmailAddresses,
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating the csr: %w", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing the csr: %w", err)
+	}
+
+	return csr, key, nil
+}
+
+// CSRPEM return the PEM encoded certificate signing request.
+func CSRPEM(csr *x509.CertificateRequest) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})
+}
+
+// ParseCSR parse a PEM (or DER) encoded certificate signing request and
+// check its signature.
+func ParseCSR(b []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the csr: %w", err)
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("checking the csr signature: %w", err)
+	}
+
+	return csr, nil
+}
+
+// Validate check the CSR against the policy. The validity is the one of
+// the certificate to issue. A nil policy reject any CSR.
+func (p *CSRPolicy) Validate(csr *x509.CertificateRequest, validity time.Duration) error {
+	if p == nil {
+		return fmt.Errorf("%w: no policy", ErrCSRPolicy)
+	}
+
+	if p.MaxValidity > 0 && validity > p.MaxValidity {
+		return fmt.Errorf("%w: validity %s exceed %s", ErrCSRPolicy, validity, p.MaxValidity)
+	}
+
+	if err := p.validateKey(csr.PublicKey); err != nil {
+		return err
+	}
+
+	if err := p.validateSubject(csr.Subject); err != nil {
+		return err
+	}
+
+	uris := make([]string, 0, len(csr.URIs))
+	for _, u := range csr.URIs {
+		uris = append(uris, u.String())
+	}
+
+	for _, san := range []struct {
+		kind            string
+		allowed, values []string
+		match           func([]string, string) bool
+	}{
+		{"dns", p.DNSNames, csr.DNSNames, matchAnyDNS},
+		{"uri", p.URIs, uris, matchAny},
+		{"email", p.Emails, csr.EmailAddresses, matchAny},
+	} {
+		for _, v := range san.values {
+			if !san.match(san.allowed, v) {
+				return fmt.Errorf("%w: %s SAN %q not allowed", ErrCSRPolicy, san.kind, v)
+			}
+		}
+	}
+
+	for _, ip := range csr.IPAddresses {
+		if !inRanges(p.IPRanges, ip) {
+			return fmt.Errorf("%w: ip SAN %q not allowed", ErrCSRPolicy, ip)
+		}
+	}
+
+	return nil
+}
+
+// validateSubject check the common name and the organizational units
+// against their (optional) allow-lists.
+func (p *CSRPolicy) validateSubject(subject pkix.Name) error {
+	if p.CommonNames != nil && !matchAny(p.CommonNames, subject.CommonName) {
+		return fmt.Errorf("%w: common name %q not allowed", ErrCSRPolicy, subject.CommonName)
+	}
+
+	if p.OrganizationalUnits == nil {
+		return nil
+	}
+
+	for _, ou := range subject.OrganizationalUnit {
+		if !matchAny(p.OrganizationalUnits, ou) {
+			return fmt.Errorf("%w: organizational unit %q not allowed", ErrCSRPolicy, ou)
+		}
+	}
+
+	return nil
+}
+
+func (p *CSRPolicy) validateKey(pub crypto.PublicKey) error {
+	algo, bits := keyAlgorithmOf(pub)
+	if algo == "" {
+		return fmt.Errorf("%w: %w: %T", ErrCSRPolicy, ErrKeyAlgorithm, pub)
+	}
+
+	if len(p.KeyAlgorithms) > 0 {
+		allowed := false
+
+		for _, a := range p.KeyAlgorithms {
+			allowed = allowed || a == algo
+		}
+
+		if !allowed {
+			return fmt.Errorf("%w: key algorithm %s not allowed", ErrCSRPolicy, algo)
+		}
+	}
+
+	minRSABits := p.MinRSABits
+	if minRSABits <= 0 {
+		minRSABits = DefaultMinRSABits
+	}
+
+	if algo == RSA && bits < minRSABits {
+		return fmt.Errorf("%w: rsa key of %d bits, %d required", ErrCSRPolicy, bits, minRSABits)
+	}
+
+	return nil
+}
+
+// SignCSR issue a leaf certificate for the CSR, once validated against the
+// policy (a nil policy reject the CSR). The subject and SANs are taken from
+// the CSR, the other settings (validity, usages, serial) from the options.
+// The returned Certificate doesn't hold any private key.
+func (ca *Certificate) SignCSR(csr *x509.CertificateRequest, policy *CSRPolicy, opts ...Option,
+) (*Certificate, error) {
+	if !ca.Cert.IsCA {
+		return nil, ErrNotCA
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("checking the csr signature: %w", err)
+	}
+
+	o := newOptions(false, opts)
+	if err := policy.Validate(csr, o.Validity); err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template(o, csr.PublicKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl.Subject, tmpl.DNSNames, tmpl.IPAddresses = csr.Subject, csr.DNSNames, csr.IPAddresses
+	tmpl.URIs, tmpl.EmailAddresses = csr.URIs, csr.EmailAddresses
+
+	return sign(ca, tmpl, csr.PublicKey, nil)
+}
+
+// keyAlgorithmOf return the algorithm (and the size for RSA) of the key.
+func keyAlgorithmOf(pub crypto.PublicKey) (KeyAlgorithm, int) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return RSA, k.N.BitLen()
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return ECDSAP256, 0
+		case elliptic.P384():
+			return ECDSAP384, 0
+		}
+	case ed25519.PublicKey:
+		return Ed25519, 0
+	}
+
+	return "", 0
+}
+
+func matchAny(patterns []string, v string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, v); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchAnyDNS match the DNS name against the patterns, the wildcard only
+// matching the left-most label (see RFC 6125).
+func matchAnyDNS(patterns []string, name string) bool {
+	label, rest, _ := strings.Cut(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+
+	for _, pattern := range patterns {
+		pLabel, pRest, _ := strings.Cut(strings.ToLower(strings.TrimSuffix(pattern, ".")), ".")
+		if label == "" || pRest != rest {
+			continue
+		}
+
+		if ok, _ := path.Match(pLabel, label); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func inRanges(cidrs []string, ip net.IP) bool {
+	for _, cidr := range cidrs {
+		if _, n, err := net.ParseCIDR(cidr); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
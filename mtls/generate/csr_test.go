@@ -0,0 +1,89 @@
+package generate
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSR(t *testing.T) {
+	requirer := require.New(t)
+
+	caCert, caKey, e := MakeCA(&pkix.Name{CommonName: "root"}, t.TempDir())
+	requirer.Nil(e)
+
+	ca := &Certificate{Cert: caCert, Key: caKey}
+
+	csr, key, e := NewCSR(WithSubject(pkix.Name{CommonName: "api", OrganizationalUnit: []string{"backend"}}),
+		WithDNSNames("api.svc.local"),
+		WithIPAddresses(net.ParseIP("10.0.0.1")), WithURIs("spiffe://example.org/api"))
+	requirer.Nil(e)
+	requirer.NotNil(key)
+
+	parsed, e := ParseCSR(CSRPEM(csr))
+	requirer.Nil(e)
+	requirer.Equal("api", parsed.Subject.CommonName)
+
+	policy := &CSRPolicy{
+		CommonNames:         []string{"api", "web-*"},
+		OrganizationalUnits: []string{"backend"},
+		DNSNames:            []string{"*.svc.local"},
+		IPRanges:            []string{"10.0.0.0/8"},
+		URIs:                []string{"spiffe://example.org/*"},
+		KeyAlgorithms:       []KeyAlgorithm{ECDSAP256, Ed25519},
+		MaxValidity:         30 * 24 * time.Hour,
+	}
+
+	t.Log("sign a compliant CSR")
+	{
+		leaf, e := ca.SignCSR(parsed, policy, WithValidity(24*time.Hour),
+			WithExtKeyUsage(x509.ExtKeyUsageServerAuth))
+		requirer.Nil(e)
+		requirer.Nil(leaf.Key)
+		requirer.Equal(key.Public(), leaf.Cert.PublicKey)
+		requirer.Equal([]string{"api.svc.local"}, leaf.Cert.DNSNames)
+		requirer.Equal("spiffe://example.org/api", leaf.Cert.URIs[0].String())
+		requirer.Nil(leaf.Cert.CheckSignatureFrom(caCert))
+	}
+
+	t.Log("policy violations")
+	{
+		_, e := ca.SignCSR(parsed, policy, WithValidity(365*24*time.Hour))
+		requirer.True(errors.Is(e, ErrCSRPolicy))
+
+		for _, p := range []CSRPolicy{
+			{DNSNames: []string{"*.other.local"}, IPRanges: policy.IPRanges, URIs: policy.URIs},
+			{DNSNames: policy.DNSNames, IPRanges: []string{"192.168.0.0/16"}, URIs: policy.URIs},
+			{DNSNames: policy.DNSNames, IPRanges: policy.IPRanges},
+			{DNSNames: policy.DNSNames, IPRanges: policy.IPRanges, URIs: policy.URIs, KeyAlgorithms: []KeyAlgorithm{RSA}},
+			{DNSNames: []string{"*.local"}, IPRanges: policy.IPRanges, URIs: policy.URIs},
+			{DNSNames: []string{"api.*"}, IPRanges: policy.IPRanges, URIs: policy.URIs},
+			{CommonNames: []string{"web-*"}, DNSNames: policy.DNSNames, IPRanges: policy.IPRanges, URIs: policy.URIs},
+			{OrganizationalUnits: []string{"frontend"}, DNSNames: policy.DNSNames, IPRanges: policy.IPRanges, URIs: policy.URIs},
+		} {
+			requirer.True(errors.Is(p.Validate(parsed, time.Hour), ErrCSRPolicy), "%+v", p)
+		}
+
+		rsaCSR, _, e := NewCSR(WithKeyAlgorithm(RSA), WithRSABits(1024))
+		requirer.Nil(e)
+		requirer.True(errors.Is((&CSRPolicy{MinRSABits: 2048}).Validate(rsaCSR, time.Hour), ErrCSRPolicy))
+		requirer.True(errors.Is((&CSRPolicy{}).Validate(rsaCSR, time.Hour), ErrCSRPolicy), "default min rsa bits")
+
+		_, e = ca.SignCSR(parsed, nil)
+		requirer.True(errors.Is(e, ErrCSRPolicy), "nil policy")
+	}
+
+	t.Log("tampered CSR")
+	{
+		raw := append([]byte{}, csr.Raw...)
+		raw[len(raw)-1] ^= 0xff
+
+		_, e := ParseCSR(raw)
+		requirer.NotNil(e)
+	}
+}
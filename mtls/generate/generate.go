@@ -1,72 +1,41 @@
+// Package generate create the certificates and keys used by the mtls package,
+// either in memory or dumped as PEM files.
 package generate
 
 import (
-	"bytes"
-	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
 	"math/big"
 	"net"
 	"net/netip"
 	"os"
 	"path/filepath"
-	"time"
 )
 
+// MakeCA generate a RSA root CA, dumped as path/ca.crt and path/ca.key.
+// See NewCA for more control over the generated CA.
 func MakeCA(subject *pkix.Name, path string) (*x509.Certificate, *rsa.PrivateKey, error) {
-	// creating a CA which will be used to sign all of our certificates using the x509 package from the Go Standard Library
-	caCert := &x509.Certificate{
-		SerialNumber:          big.NewInt(2019),
-		Subject:               *subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(10*365, 0, 0),
-		IsCA:                  true, // <- indicating this certificate is a CA certificate.
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-		BasicConstraintsValid: true,
-	}
-
-	// generate a private key for the CA
-	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	ca, err := NewCA(WithSubject(*subject), WithKeyAlgorithm(RSA),
+		WithExtKeyUsage(x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth))
 	if err != nil {
-		return nil, nil, fmt.Errorf("generating the CA Private key: %w", err)
+		return nil, nil, fmt.Errorf("generating the CA: %w", err)
 	}
 
-	// create the CA certificate
-	caBytes, err := x509.CreateCertificate(rand.Reader, caCert, caCert, &caKey.PublicKey, caKey)
-	if err != nil {
-		return nil, nil, fmt.Errorf("creating the CA Certificate: %w", err)
+	if err := ca.WriteFiles(path, "ca"); err != nil {
+		return nil, nil, fmt.Errorf("writing the CA files: %w", err)
 	}
 
-	// Create the CA PEM files
-	caPEM := new(bytes.Buffer)
-	pem.Encode(caPEM, &pem.Block{Type: "CERTIFICATE", Bytes: caBytes})
-
-	p := filepath.Join(path, "ca.crt")
-	fmt.Printf("dumping %q\n", p)
-
-	if err := os.WriteFile(p, caPEM.Bytes(), 0644); err != nil {
-		return nil, nil, fmt.Errorf("writing the CA certificate file: %w", err)
-	}
+	caKey, _ := ca.Key.(*rsa.PrivateKey)
 
-	caPrivKeyPEM := new(bytes.Buffer)
-	pem.Encode(caPrivKeyPEM, &pem.Block{
-		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(caKey),
-	})
-
-	p = filepath.Join(path, "ca.key")
-	fmt.Printf("dumping %q\n", p)
-
-	if err := os.WriteFile(p, caPrivKeyPEM.Bytes(), 0644); err != nil {
-		return nil, nil, fmt.Errorf("writing the CA certificate file: %w", err)
-	}
-
-	return caCert, caKey, nil
+	return ca.Cert, caKey, nil
 }
 
+// MakeCert generate a RSA certificate signed by the CA, valid for localhost,
+// the loopback addresses and the given ip. It's dumped as path/name.crt and
+// path/name.key. See Certificate.Issue for more control over the generated
+// certificate.
 func MakeCert(caCert *x509.Certificate, caKey *rsa.PrivateKey,
 	subject *pkix.Name, name, ip, path string,
 ) error {
@@ -75,49 +44,33 @@ func MakeCert(caCert *x509.Certificate, caKey *rsa.PrivateKey,
 		return fmt.Errorf("parsing ip address: %w", err)
 	}
 
-	cert := &x509.Certificate{
-		SerialNumber: big.NewInt(1658),
-		Subject:      *subject,
-		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback, ipAddr.AsSlice()},
+	ca := &Certificate{Cert: caCert, Key: caKey}
 
-		DNSNames:     []string{"localhost"},
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(10, 0, 0),
-		SubjectKeyId: []byte{1, 2, 3, 4, 6},
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:     x509.KeyUsageDigitalSignature,
-	}
-
-	certKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		return fmt.Errorf("generating the Key: %w", err)
-	}
-
-	certBytes, err := x509.CreateCertificate(rand.Reader, cert, caCert, &certKey.PublicKey, caKey)
+	cert, err := ca.Issue(WithSubject(*subject), WithKeyAlgorithm(RSA), WithRSABits(4096),
+		WithDNSNames("localhost"), WithValidity(10*DefaultCertValidity),
+		WithIPAddresses(net.IPv4(127, 0, 0, 1), net.IPv6loopback, ipAddr.AsSlice()))
 	if err != nil {
 		return fmt.Errorf("generate the certificate: %w", err)
 	}
 
-	certPEM := new(bytes.Buffer)
-	pem.Encode(certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
-
-	p := filepath.Join(path, name+".crt")
-	fmt.Printf("dumping %q\n", p)
-
-	if err := os.WriteFile(p, certPEM.Bytes(), 0644); err != nil {
-		return fmt.Errorf("writing the CA certificate file: %w", err)
+	if err := cert.WriteFiles(path, name); err != nil {
+		return fmt.Errorf("writing the certificate files: %w", err)
 	}
 
-	certKeyPEM := new(bytes.Buffer)
-	pem.Encode(certKeyPEM, &pem.Block{
-		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(certKey),
-	})
+	return nil
+}
 
-	p = filepath.Join(path, name+".key")
-	fmt.Printf("dumping %q\n", p)
+// MakeCRL create a CRL signed by the CA, revoking the given serial numbers.
+// The CRL is dumped PEM encoded as path/ca.crl. See Certificate.CRL for
+// the in memory version.
+func MakeCRL(caCert *x509.Certificate, caKey *rsa.PrivateKey, path string, serials ...*big.Int) error {
+	crl, err := (&Certificate{Cert: caCert, Key: caKey}).CRL(serials...)
+	if err != nil {
+		return err
+	}
 
-	if err := os.WriteFile(p, certKeyPEM.Bytes(), 0644); err != nil {
-		return fmt.Errorf("writing the CA certificate file: %w", err)
+	if err := os.WriteFile(filepath.Join(path, "ca.crl"), crl, _certPerm); err != nil {
+		return fmt.Errorf("writing the CRL file: %w", err)
 	}
 
 	return nil
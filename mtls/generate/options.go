@@ -0,0 +1,147 @@
+package generate
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"time"
+)
+
+// KeyAlgorithm is the algorithm of the generated private keys.
+type KeyAlgorithm string
+
+const (
+	RSA       KeyAlgorithm = "rsa"
+	ECDSAP256 KeyAlgorithm = "ecdsa-p256"
+	ECDSAP384 KeyAlgorithm = "ecdsa-p384"
+	Ed25519   KeyAlgorithm = "ed25519"
+
+	// DefaultKeyAlgorithm is the algorithm used if none is specified.
+	DefaultKeyAlgorithm = ECDSAP256
+	// DefaultRSABits is the RSA key size used if none is specified.
+	DefaultRSABits = 2048
+	// DefaultMinRSABits is the minimal RSA key size a CSRPolicy accept if
+	// none is specified.
+	DefaultMinRSABits = 2048
+	// DefaultCAValidity is the validity of the generated CA.
+	DefaultCAValidity = 10 * 365 * 24 * time.Hour
+	// DefaultCertValidity is the validity of the generated certificates.
+	DefaultCertValidity = 365 * 24 * time.Hour
+)
+
+type (
+	// Options describe the certificate to generate.
+	Options struct {
+		NotBefore    time.Time
+		Serial       *big.Int
+		Subject      pkix.Name
+		KeyAlgorithm KeyAlgorithm
+		DNSNames     []string
+		IPAddresses  []net.IP
+		URIs         []string
+		Emails       []string
+		ExtKeyUsage  []x509.ExtKeyUsage
+		Validity     time.Duration
+		RSABits      int
+		// MaxPathLen constraint the CA path length, negative if unset.
+		MaxPathLen int
+		KeyUsage   x509.KeyUsage
+	}
+
+	// Option customize the Options of the certificate to generate.
+	Option func(*Options)
+)
+
+// WithSubject set the certificate subject.
+func WithSubject(subject pkix.Name) Option {
+	return func(o *Options) { o.Subject = subject }
+}
+
+// WithCommonName set the certificate subject common name.
+func WithCommonName(cn string) Option {
+	return func(o *Options) { o.Subject.CommonName = cn }
+}
+
+// WithKeyAlgorithm set the algorithm of the generated key.
+func WithKeyAlgorithm(algo KeyAlgorithm) Option {
+	return func(o *Options) { o.KeyAlgorithm = algo }
+}
+
+// WithRSABits set the size of the generated RSA key.
+func WithRSABits(bits int) Option {
+	return func(o *Options) { o.RSABits = bits }
+}
+
+// WithSerial set the certificate serial number (default to a random one).
+func WithSerial(serial *big.Int) Option {
+	return func(o *Options) { o.Serial = serial }
+}
+
+// WithNotBefore set the start of the validity window (default to now).
+func WithNotBefore(t time.Time) Option {
+	return func(o *Options) { o.NotBefore = t }
+}
+
+// WithValidity set the duration of the validity window.
+func WithValidity(d time.Duration) Option {
+	return func(o *Options) { o.Validity = d }
+}
+
+// WithDNSNames add some DNS SANs.
+func WithDNSNames(names ...string) Option {
+	return func(o *Options) { o.DNSNames = append(o.DNSNames, names...) }
+}
+
+// WithIPAddresses add some IP SANs.
+func WithIPAddresses(ips ...net.IP) Option {
+	return func(o *Options) { o.IPAddresses = append(o.IPAddresses, ips...) }
+}
+
+// WithURIs add some URI SANs (ie. SPIFFE IDs).
+func WithURIs(uris ...string) Option {
+	return func(o *Options) { o.URIs = append(o.URIs, uris...) }
+}
+
+// WithEmails add some e-mail SANs.
+func WithEmails(emails ...string) Option {
+	return func(o *Options) { o.Emails = append(o.Emails, emails...) }
+}
+
+// WithKeyUsage override the certificate key usage.
+func WithKeyUsage(usage x509.KeyUsage) Option {
+	return func(o *Options) { o.KeyUsage = usage }
+}
+
+// WithExtKeyUsage override the certificate extended key usages.
+func WithExtKeyUsage(usages ...x509.ExtKeyUsage) Option {
+	return func(o *Options) { o.ExtKeyUsage = usages }
+}
+
+// WithMaxPathLen constraint the number of intermediate CA allowed under
+// the generated CA.
+func WithMaxPathLen(n int) Option {
+	return func(o *Options) { o.MaxPathLen = n }
+}
+
+func newOptions(isCA bool, opts []Option) *Options {
+	o := &Options{
+		KeyAlgorithm: DefaultKeyAlgorithm,
+		RSABits:      DefaultRSABits,
+		Validity:     DefaultCertValidity,
+		MaxPathLen:   -1,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	if isCA {
+		o.Validity = DefaultCAValidity
+		o.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
@@ -0,0 +1,477 @@
+package generate
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // only used for the subject key id (RFC 5280 4.2.1.2)
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/burgesQ/gommon/mtls/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	_serialBits = 128
+	_certPerm   = 0o644
+	_keyPerm    = 0o600
+)
+
+var (
+	// ErrNotCA error is returned when issuing from a non CA certificate.
+	ErrNotCA = errors.New("issuer isn't a CA")
+	// ErrKeyAlgorithm error is returned for unsupported key algorithm.
+	ErrKeyAlgorithm = errors.New("unsupported key algorithm")
+	// ErrNoCertificate error is returned if no PEM certificate is found.
+	ErrNoCertificate = errors.New("no PEM certificate found")
+	// ErrNoKey error is returned if no PEM private key is found.
+	ErrNoKey = errors.New("no PEM private key found")
+	// ErrKeyPassphrase error is returned if the key is encrypted while no
+	// passphrase is given.
+	ErrKeyPassphrase = errors.New("encrypted private key without passphrase")
+)
+
+// Certificate hold a generated certificate, its private key and the chain
+// of intermediate CA which issued it (the root excluded).
+type Certificate struct {
+	Cert  *x509.Certificate
+	Key   crypto.Signer
+	Chain []*x509.Certificate
+}
+
+// NewCA generate a self-signed root CA.
+func NewCA(opts ...Option) (*Certificate, error) {
+	return create(nil, newOptions(true, opts), true)
+}
+
+// NewIntermediate generate an intermediate CA signed by the ca.
+func (ca *Certificate) NewIntermediate(opts ...Option) (*Certificate, error) {
+	if !ca.Cert.IsCA {
+		return nil, ErrNotCA
+	}
+
+	return create(ca, newOptions(true, opts), true)
+}
+
+// Issue generate a leaf certificate signed by the ca.
+func (ca *Certificate) Issue(opts ...Option) (*Certificate, error) {
+	if !ca.Cert.IsCA {
+		return nil, ErrNotCA
+	}
+
+	return create(ca, newOptions(false, opts), false)
+}
+
+// Load parse a PEM encoded certificate (optionally followed by its chain)
+// and its PEM encoded private key, ie. to issue from an existing CA. An
+// encrypted key is decrypted with the passphrase.
+func Load(certPEM, keyPEM []byte, passphrase ...[]byte) (*Certificate, error) {
+	var certs []*x509.Certificate
+
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing the certificate: %w", err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, ErrNoCertificate
+	}
+
+	key, err := ParseKey(keyPEM, passphrase...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificate{Cert: certs[0], Key: key, Chain: certs[1:]}, nil
+}
+
+// LoadFiles is the same as Load, reading the PEM content from files.
+func LoadFiles(certPath, keyPath string, passphrase ...[]byte) (*Certificate, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading the certificate file: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading the key file: %w", err)
+	}
+
+	return Load(certPEM, keyPEM, passphrase...)
+}
+
+// ParseKey parse a PEM encoded PKCS #8, PKCS #1 or SEC 1 private key. An
+// encrypted PKCS #8 key is decrypted with the passphrase, a legacy
+// encrypted PEM is rejected (see pkcs8.ErrLegacyEncryption).
+func ParseKey(keyPEM []byte, passphrase ...[]byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, ErrNoKey
+	}
+
+	if pkcs8.IsEncrypted(block) {
+		if len(passphrase) == 0 {
+			return nil, ErrKeyPassphrase
+		}
+
+		var err error
+		if block, err = pkcs8.Decrypt(block, passphrase[0]); err != nil {
+			return nil, fmt.Errorf("decrypting the private key: %w", err)
+		}
+	}
+
+	var (
+		key any
+		err error
+	)
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing the private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrKeyAlgorithm, key)
+	}
+
+	return signer, nil
+}
+
+// CertPEM return the PEM encoded certificate followed by its chain.
+func (c *Certificate) CertPEM() []byte {
+	out := new(bytes.Buffer)
+
+	for _, cert := range append([]*x509.Certificate{c.Cert}, c.Chain...) {
+		out.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+
+	return out.Bytes()
+}
+
+// KeyPEM return the PEM encoded (PKCS #8) private key.
+func (c *Certificate) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling the private key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// EncryptedKeyPEM return the PEM encoded private key, encrypted with the
+// passphrase (PKCS #8, see pkcs8.Encrypt).
+func (c *Certificate) EncryptedKeyPEM(passphrase []byte) ([]byte, error) {
+	block, err := pkcs8.Encrypt(c.Key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// PKCS12 return the certificate, its chain and its key as a PKCS #12 (PFX)
+// bundle protected by the password, encrypted with AES-256 and PBKDF2. The
+// legacy flag use 3DES instead, for the older Windows and Java versions.
+func (c *Certificate) PKCS12(password string, legacy ...bool) ([]byte, error) {
+	enc := pkcs12.Modern
+	if len(legacy) > 0 && legacy[0] {
+		enc = pkcs12.LegacyDES
+	}
+
+	pfx, err := enc.Encode(c.Key, c.Cert, c.Chain, password)
+	if err != nil {
+		return nil, fmt.Errorf("encoding the pkcs12 bundle: %w", err)
+	}
+
+	return pfx, nil
+}
+
+// WritePKCS12File dump the PKCS #12 bundle (see PKCS12) as dir/name.p12,
+// readable by the owner only.
+func (c *Certificate) WritePKCS12File(dir, name, password string, legacy ...bool) error {
+	pfx, err := c.PKCS12(password, legacy...)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, name+".p12"), pfx, _keyPerm); err != nil {
+		return fmt.Errorf("writing the pkcs12 file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadPKCS12 parse a PKCS #12 (PFX) bundle protected by the password.
+func LoadPKCS12(pfx []byte, password string) (*Certificate, error) {
+	key, cert, chain, err := pkcs12.DecodeChain(pfx, password)
+	if err != nil {
+		return nil, fmt.Errorf("decoding the pkcs12 bundle: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrKeyAlgorithm, key)
+	}
+
+	return &Certificate{Cert: cert, Key: signer, Chain: chain}, nil
+}
+
+// TLSCertificate return the certificate, its chain and its key as a
+// tls.Certificate.
+func (c *Certificate) TLSCertificate() tls.Certificate {
+	out := tls.Certificate{PrivateKey: c.Key, Leaf: c.Cert}
+
+	for _, cert := range append([]*x509.Certificate{c.Cert}, c.Chain...) {
+		out.Certificate = append(out.Certificate, cert.Raw)
+	}
+
+	return out
+}
+
+// CertPool return a pool holding the certificate, ie. to trust a CA.
+func (c *Certificate) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Cert)
+
+	return pool
+}
+
+// CRL return a PEM encoded CRL signed by the ca, revoking the given serial
+// numbers. The CRL is valid for a week.
+func (ca *Certificate) CRL(serials ...*big.Int) ([]byte, error) {
+	now := time.Now()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(now.UnixNano()),
+		ThisUpdate: now,
+		NextUpdate: now.AddDate(0, 0, 7),
+	}
+
+	for _, serial := range serials {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: serial, RevocationTime: now})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.Cert, ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("creating the CRL: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// WriteFiles dump the certificate (followed by its chain) as dir/name.crt
+// and its private key as dir/name.key, readable by the owner only.
+func (c *Certificate) WriteFiles(dir, name string) error {
+	keyPEM, err := c.KeyPEM()
+	if err != nil {
+		return err
+	}
+
+	return c.writeFiles(dir, name, keyPEM)
+}
+
+// WriteEncryptedFiles is the same as WriteFiles, the private key being
+// encrypted with the passphrase (see EncryptedKeyPEM).
+func (c *Certificate) WriteEncryptedFiles(dir, name string, passphrase []byte) error {
+	keyPEM, err := c.EncryptedKeyPEM(passphrase)
+	if err != nil {
+		return err
+	}
+
+	return c.writeFiles(dir, name, keyPEM)
+}
+
+func (c *Certificate) writeFiles(dir, name string, keyPEM []byte) error {
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), c.CertPEM(), _certPerm); err != nil {
+		return fmt.Errorf("writing the certificate file: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, _keyPerm); err != nil {
+		return fmt.Errorf("writing the key file: %w", err)
+	}
+
+	return nil
+}
+
+// create generate the key and the certificate, signed by the issuer or
+// self-signed if the issuer is nil.
+func create(issuer *Certificate, o *Options, isCA bool) (*Certificate, error) {
+	key, err := generateKey(o.KeyAlgorithm, o.RSABits)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template(o, key.Public(), isCA)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := sign(issuer, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Key = key
+
+	return out, nil
+}
+
+// sign create the certificate from the template, signed by the issuer or
+// self-signed (by key) if the issuer is nil.
+func sign(issuer *Certificate, tmpl *x509.Certificate, pub crypto.PublicKey, key crypto.Signer,
+) (*Certificate, error) {
+	parent, signer := tmpl, key
+	if issuer != nil {
+		parent, signer = issuer.Cert, issuer.Key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		return nil, fmt.Errorf("creating the certificate: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the certificate: %w", err)
+	}
+
+	out := &Certificate{Cert: cert}
+
+	if issuer != nil && !isSelfSigned(issuer.Cert) {
+		out.Chain = append([]*x509.Certificate{issuer.Cert}, issuer.Chain...)
+	}
+
+	return out, nil
+}
+
+func template(o *Options, pub crypto.PublicKey, isCA bool) (*x509.Certificate, error) {
+	serial := o.Serial
+	if serial == nil {
+		var err error
+		if serial, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), _serialBits)); err != nil {
+			return nil, fmt.Errorf("generating the serial number: %w", err)
+		}
+	}
+
+	ski, err := subjectKeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := o.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        o.Subject,
+		NotBefore:      notBefore,
+		NotAfter:       notBefore.Add(o.Validity),
+		SubjectKeyId:   ski,
+		DNSNames:       o.DNSNames,
+		IPAddresses:    o.IPAddresses,
+		EmailAddresses: o.Emails,
+		KeyUsage:       o.KeyUsage,
+		ExtKeyUsage:    o.ExtKeyUsage,
+	}
+
+	for _, raw := range o.URIs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parsing the uri SAN %q: %w", raw, err)
+		}
+
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+
+	if isCA {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.MaxPathLen, tmpl.MaxPathLenZero = o.MaxPathLen, o.MaxPathLen == 0
+	}
+
+	return tmpl, nil
+}
+
+func generateKey(algo KeyAlgorithm, bits int) (crypto.Signer, error) {
+	var (
+		key crypto.Signer
+		err error
+	)
+
+	switch algo {
+	case RSA:
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	case ECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case Ed25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrKeyAlgorithm, algo)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("generating the %s private key: %w", algo, err)
+	}
+
+	return key, nil
+}
+
+// subjectKeyID compute the key identifier as the sha1 of the public key
+// bit string (RFC 5280 4.2.1.2 method 1).
+func subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling the public key: %w", err)
+	}
+
+	var spki struct {
+		Algorithm asn1.RawValue
+		PublicKey asn1.BitString
+	}
+
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, fmt.Errorf("parsing the public key: %w", err)
+	}
+
+	sum := sha1.Sum(spki.PublicKey.Bytes) //nolint:gosec
+
+	return sum[:], nil
+}
+
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil
+}
@@ -0,0 +1,125 @@
+package generate
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPKI(t *testing.T) {
+	requirer := require.New(t)
+
+	root, e := NewCA(WithCommonName("root"), WithKeyAlgorithm(Ed25519), WithMaxPathLen(1))
+	requirer.Nil(e)
+	requirer.True(root.Cert.IsCA)
+	requirer.Equal(1, root.Cert.MaxPathLen)
+	requirer.Empty(root.Chain)
+	requirer.IsType(ed25519.PrivateKey{}, root.Key)
+
+	inter, e := root.NewIntermediate(WithCommonName("intermediate"), WithMaxPathLen(0))
+	requirer.Nil(e)
+	requirer.True(inter.Cert.MaxPathLenZero)
+	requirer.Equal([]*x509.Certificate(nil), inter.Chain)
+	requirer.IsType(&ecdsa.PrivateKey{}, inter.Key)
+
+	notBefore := time.Now().Add(-time.Hour).Truncate(time.Second)
+	leaf, e := inter.Issue(WithCommonName("leaf"), WithKeyAlgorithm(RSA),
+		WithNotBefore(notBefore), WithValidity(24*time.Hour),
+		WithDNSNames("api.svc.local"), WithIPAddresses(net.ParseIP("10.0.0.1")),
+		WithURIs("spiffe://example.org/ns/prod/sa/api"), WithEmails("ops@example.org"),
+		WithExtKeyUsage(x509.ExtKeyUsageServerAuth))
+	requirer.Nil(e)
+	requirer.IsType(&rsa.PrivateKey{}, leaf.Key)
+	requirer.Equal([]*x509.Certificate{inter.Cert}, leaf.Chain)
+	requirer.Equal(notBefore.UTC(), leaf.Cert.NotBefore)
+	requirer.Equal(notBefore.Add(24*time.Hour).UTC(), leaf.Cert.NotAfter)
+	requirer.Equal([]string{"api.svc.local"}, leaf.Cert.DNSNames)
+	requirer.Equal("spiffe://example.org/ns/prod/sa/api", leaf.Cert.URIs[0].String())
+	requirer.Equal([]string{"ops@example.org"}, leaf.Cert.EmailAddresses)
+	requirer.NotEqual(inter.Cert.SerialNumber, leaf.Cert.SerialNumber)
+	requirer.NotEmpty(leaf.Cert.SubjectKeyId)
+	requirer.Equal(inter.Cert.SubjectKeyId, leaf.Cert.AuthorityKeyId)
+
+	t.Log("the chain verify")
+	{
+		roots, inters := x509.NewCertPool(), x509.NewCertPool()
+		roots.AddCert(root.Cert)
+		inters.AddCert(inter.Cert)
+
+		_, e := leaf.Cert.Verify(x509.VerifyOptions{
+			Roots: roots, Intermediates: inters, DNSName: "api.svc.local",
+		})
+		requirer.Nil(e)
+	}
+
+	t.Log("invalid issuer / algorithm")
+	{
+		_, e := leaf.Issue(WithCommonName("nope"))
+		requirer.True(errors.Is(e, ErrNotCA))
+
+		_, e = NewCA(WithKeyAlgorithm("dsa"))
+		requirer.True(errors.Is(e, ErrKeyAlgorithm))
+	}
+
+	t.Log("written files")
+	{
+		d := t.TempDir()
+		requirer.Nil(leaf.WriteFiles(d, "leaf"))
+
+		fi, e := os.Stat(filepath.Join(d, "leaf.key"))
+		requirer.Nil(e)
+		requirer.Equal(os.FileMode(0o600), fi.Mode().Perm())
+
+		pair, e := tls.LoadX509KeyPair(filepath.Join(d, "leaf.crt"), filepath.Join(d, "leaf.key"))
+		requirer.Nil(e)
+		requirer.Len(pair.Certificate, 2, "the intermediate should be bundled")
+	}
+
+	t.Log("encrypted key")
+	{
+		d := t.TempDir()
+		requirer.Nil(leaf.WriteEncryptedFiles(d, "leaf", []byte("secret")))
+
+		keyPEM, e := os.ReadFile(filepath.Join(d, "leaf.key"))
+		requirer.Nil(e)
+		requirer.Contains(string(keyPEM), "ENCRYPTED PRIVATE KEY")
+
+		_, e = LoadFiles(filepath.Join(d, "leaf.crt"), filepath.Join(d, "leaf.key"))
+		requirer.True(errors.Is(e, ErrKeyPassphrase))
+
+		loaded, e := LoadFiles(filepath.Join(d, "leaf.crt"), filepath.Join(d, "leaf.key"), []byte("secret"))
+		requirer.Nil(e)
+		requirer.True(leaf.Key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(loaded.Key.Public()))
+	}
+
+	t.Log("pkcs12 bundle")
+	{
+		for _, legacy := range []bool{false, true} {
+			d := t.TempDir()
+			requirer.Nil(leaf.WritePKCS12File(d, "leaf", "secret", legacy))
+
+			pfx, e := os.ReadFile(filepath.Join(d, "leaf.p12"))
+			requirer.Nil(e)
+
+			_, e = LoadPKCS12(pfx, "wrong")
+			requirer.NotNil(e)
+
+			loaded, e := LoadPKCS12(pfx, "secret")
+			requirer.Nil(e)
+			requirer.True(leaf.Cert.Equal(loaded.Cert))
+			requirer.Len(loaded.Chain, 1, "the intermediate should be bundled")
+			requirer.True(leaf.Key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(loaded.Key.Public()))
+		}
+	}
+}
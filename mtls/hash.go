@@ -0,0 +1,102 @@
+package mtls
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Algorithms supported by Config.HashAlgorithm.
+const (
+	HashSHA256 = "sha256"
+	HashSHA384 = "sha384"
+	HashSHA512 = "sha512"
+
+	// DefaultHashAlgorithm is used if the Config doesn't specify any.
+	DefaultHashAlgorithm = HashSHA256
+)
+
+var (
+	// ErrHashAlgorithm error is returned for an unsupported hash algorithm.
+	ErrHashAlgorithm = errors.New("unsupported hash algorithm")
+
+	// ErrHashMismatch error is returned if the loaded material doesn't
+	// match the Config Hash (ie. tampered or partially written files).
+	ErrHashMismatch = errors.New("material does not match the config hash")
+)
+
+// newHash return the constructor of the named hash algorithm.
+func newHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "", HashSHA256:
+		return sha256.New, nil
+	case HashSHA384:
+		return sha512.New384, nil
+	case HashSHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w: %q (%s, %s or %s)",
+			ErrHashAlgorithm, algorithm, HashSHA256, HashSHA384, HashSHA512)
+	}
+}
+
+// ComputeHash return the hash of the cert + key + ca content (and of the
+// SNI entries and routes CA), using the config HashAlgorithm.
+func (cfg Config) ComputeHash() (string, error) {
+	raw, err := readMaterial(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	return cfg.checkHash(raw)
+}
+
+// checkHash return the hash of the material, failing if it doesn't match
+// the config Hash (if any).
+func (cfg Config) checkHash(raw rawMaterial) (string, error) {
+	fn, err := newHash(cfg.HashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	sum := raw.hash(fn)
+
+	if cfg.Hash != "" &&
+		subtle.ConstantTimeCompare([]byte(strings.ToLower(cfg.Hash)), []byte(sum)) != 1 {
+		return "", fmt.Errorf("%w: cert [%s], key [%s], CA [%s]", ErrHashMismatch,
+			describeSource(cfg.Cert), describeSource(cfg.Key), describeSource(cfg.Ca))
+	}
+
+	return sum, nil
+}
+
+// hash return the hex digest of the material content.
+func (raw rawMaterial) hash(fn func() hash.Hash) string {
+	h := fn()
+
+	for _, b := range [][]byte{raw.cert, raw.key, raw.ca} {
+		h.Write(b)
+		h.Write([]byte{0})
+	}
+
+	if raw.intermediates != nil {
+		h.Write(raw.intermediates)
+		h.Write([]byte{0})
+	}
+
+	for _, sub := range raw.sni {
+		h.Write([]byte(sub.hash(fn)))
+	}
+
+	for _, b := range raw.routes {
+		h.Write(b)
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
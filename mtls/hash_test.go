@@ -0,0 +1,93 @@
+package mtls
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash(t *testing.T) {
+	requirer := require.New(t)
+
+	server, _ := newTestPKI(t).configs(t)
+
+	t.Log("algorithms")
+	{
+		for alg, size := range map[string]int{"": 64, HashSHA256: 64, HashSHA384: 96, "SHA512": 128} {
+			cfg := server
+			cfg.HashAlgorithm = alg
+
+			h, e := cfg.ComputeHash()
+			requirer.Nil(e)
+			requirer.Len(h, size, alg)
+
+			again, e := cfg.ComputeHash()
+			requirer.Nil(e)
+			requirer.Equal(h, again)
+		}
+
+		cfg := server
+		cfg.HashAlgorithm = "md5"
+
+		_, e := cfg.ComputeHash()
+		requirer.True(errors.Is(e, ErrHashAlgorithm))
+		requirer.True(errors.Is(cfg.Validate(), ErrHashAlgorithm))
+	}
+
+	t.Log("user supplied hash")
+	{
+		h, e := server.ComputeHash()
+		requirer.Nil(e)
+
+		cfg := server
+		cfg.Hash = strings.ToUpper(h)
+
+		_, e = GetTLSCfg(cfg)
+		requirer.Nil(e)
+		requirer.Nil(cfg.Validate())
+
+		cfg.Hash = strings.Repeat("0", len(h))
+
+		_, e = GetTLSCfg(cfg)
+		requirer.True(errors.Is(e, ErrHashMismatch))
+		requirer.True(errors.Is(cfg.Validate(), ErrHashMismatch))
+	}
+
+	t.Log("pinned reloader reject the changed material")
+	{
+		d := t.TempDir()
+		cert, key := issueTestCert(t, d, "first")
+
+		h, e := Config{Cert: cert, Key: key}.ComputeHash()
+		requirer.Nil(e)
+
+		r, e := NewReloader(Config{Cert: cert, Key: key, Hash: h}, WithReloadErrorHandler(func(error) {}))
+		requirer.Nil(e)
+		requirer.Equal(h, r.Config().Hash)
+
+		ok, e := r.Reload()
+		requirer.Nil(e)
+		requirer.False(ok)
+
+		// partial write
+		requirer.Nil(os.WriteFile(key, []byte("-----BEGIN"), 0o600))
+
+		_, e = r.Reload()
+		requirer.NotNil(e)
+
+		ncert, _ := issueTestCert(t, t.TempDir(), "second")
+		b, e := os.ReadFile(ncert)
+		requirer.Nil(e)
+		requirer.Nil(os.WriteFile(cert, b, 0o600))
+
+		_, e = r.Reload()
+		requirer.True(errors.Is(e, ErrHashMismatch))
+
+		c, e := r.GetCertificate(nil)
+		requirer.Nil(e)
+		requirer.Equal("first", leafCN(t, c))
+	}
+}
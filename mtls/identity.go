@@ -0,0 +1,317 @@
+package mtls
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+)
+
+type (
+	// Identity describe the client certificate of an mTLS connection.
+	Identity struct {
+		CommonName string   `json:"common_name"`
+		DNSNames   []string `json:"dns_names,omitempty"`
+		IPs        []string `json:"ips,omitempty"`
+		URIs       []string `json:"uris,omitempty"`
+		Emails     []string `json:"emails,omitempty"`
+		// SPIFFEID is the first spiffe:// URI SAN, if any.
+		SPIFFEID string `json:"spiffe_id,omitempty"`
+		// Serial is the hexadecimal serial number.
+		Serial string `json:"serial"`
+		Issuer string `json:"issuer"`
+		// Fingerprint is the hexadecimal sha256 digest of the certificate.
+		Fingerprint string `json:"fingerprint"`
+		// Level is the authentication level enforced for the connection.
+		Level Level `json:"level"`
+		// Verified is true if the certificate chain has been verified by
+		// the handshake, either by crypto/tls or against the
+		// Config.Intermediates (see ConnContext).
+		Verified bool `json:"verified"`
+		// CA is the label of the CA source which verified the chain (see
+		// CASource), set by the IdentityMiddleware.
+		CA string `json:"ca,omitempty"`
+		// Certificate is the client certificate.
+		Certificate *x509.Certificate `json:"-"`
+		// Chain is the verified chain (or the presented one if not verified).
+		Chain []*x509.Certificate `json:"-"`
+	}
+
+	// IdentityOption customize the IdentityMiddleware.
+	IdentityOption func(*identityMiddleware)
+
+	identityMiddleware struct {
+		log      *slog.Logger
+		policy   *Policy
+		levels   *levelResolver
+		cas      *caLabels
+		required bool
+	}
+
+	identityKey struct{}
+
+	handshakeKey struct{}
+
+	// recordingListener wrap the accepted connections in recordingConn.
+	recordingListener struct {
+		net.Listener
+	}
+
+	// recordingConn record the chains verified by the handshake of the
+	// connection (see serverState.verifyConnection).
+	recordingConn struct {
+		net.Conn
+		chains [][]*x509.Certificate
+		mu     sync.Mutex
+	}
+
+	// stringAddr is a net.Addr from an http.Request RemoteAddr.
+	stringAddr string
+)
+
+func (a stringAddr) Network() string { return "tcp" }
+func (a stringAddr) String() string  { return string(a) }
+
+// WithIdentityPolicy reject (403) the requests whose identity isn't
+// verified or isn't authorized by the policy.
+func WithIdentityPolicy(p *Policy) IdentityOption {
+	return func(m *identityMiddleware) { m.policy = p }
+}
+
+// WithIdentityRequired reject (401) the requests without client certificate.
+func WithIdentityRequired() IdentityOption {
+	return func(m *identityMiddleware) { m.required = true }
+}
+
+// WithIdentityLogger set the logger of the middleware (default to
+// slog.Default). The identities are logged at the debug level, the
+// rejections at the warn level.
+func WithIdentityLogger(l *slog.Logger) IdentityOption {
+	return func(m *identityMiddleware) { m.log = l }
+}
+
+// IdentityMiddleware return an http middleware storing the client Identity
+// in the request context (see IdentityFromContext). The cfg is the one
+// served by the listener, used to report the enforced Level and the CA
+// which verified the client.
+// The chains verified against the Config.Intermediates are reported if the
+// http.Server use ConnContext (see Server).
+func IdentityMiddleware(cfg Config, opts ...IdentityOption) (func(http.Handler) http.Handler, error) {
+	m := &identityMiddleware{log: slog.Default()}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if err := m.policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	levels, err := newLevelResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.cas, err = newCALabels(cfg); err != nil {
+		return nil, err
+	}
+
+	m.levels = levels
+
+	return m.wrap, nil
+}
+
+// NewIdentity return the identity of the client certificate of the
+// connection, false if the client didn't present any. The certificate is
+// verified if crypto/tls verified its chain; the chains verified against
+// the Config.Intermediates are only known by the IdentityMiddleware (see
+// ConnContext).
+func NewIdentity(cs *tls.ConnectionState, lvl Level) (*Identity, bool) {
+	if cs == nil {
+		return nil, false
+	}
+
+	return newIdentity(cs, cs.VerifiedChains, lvl)
+}
+
+// newIdentity return the identity of the client certificate, verified if
+// some verified chains are given.
+func newIdentity(cs *tls.ConnectionState, chains [][]*x509.Certificate, lvl Level) (*Identity, bool) {
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return nil, false
+	}
+
+	id := &Identity{Level: lvl, Chain: cs.PeerCertificates}
+	if len(chains) > 0 && len(chains[0]) > 0 {
+		id.Verified, id.Chain = true, chains[0]
+	}
+
+	c := id.Chain[0]
+	sum := sha256.Sum256(c.Raw)
+
+	id.Certificate = c
+	id.CommonName = c.Subject.CommonName
+	id.DNSNames, id.Emails = c.DNSNames, c.EmailAddresses
+	id.Serial = c.SerialNumber.Text(16) //nolint:gomnd
+	id.Issuer = c.Issuer.String()
+	id.Fingerprint = hex.EncodeToString(sum[:])
+
+	for _, ip := range c.IPAddresses {
+		id.IPs = append(id.IPs, ip.String())
+	}
+
+	for _, u := range c.URIs {
+		id.URIs = append(id.URIs, u.String())
+
+		if u.Scheme == "spiffe" && id.SPIFFEID == "" {
+			id.SPIFFEID = u.String()
+		}
+	}
+
+	return id, true
+}
+
+// ConnContext is an http.Server ConnContext storing the connection in the
+// request context, so the IdentityMiddleware report the chains verified
+// by the handshake, including the ones verified against the
+// Config.Intermediates. The connections must be accepted by a LoadListner
+// or WrapListener listener. The Server set it.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		if rc, ok := tc.NetConn().(*recordingConn); ok {
+			return context.WithValue(ctx, handshakeKey{}, rc)
+		}
+	}
+
+	return ctx
+}
+
+// handshakeChains return the chains recorded by the handshake of the
+// request connection (see ConnContext).
+func handshakeChains(ctx context.Context) ([][]*x509.Certificate, bool) {
+	rc, ok := ctx.Value(handshakeKey{}).(*recordingConn)
+	if !ok {
+		return nil, false
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.chains, len(rc.chains) > 0
+}
+
+func (l *recordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingConn{Conn: conn}, nil
+}
+
+// record store the chains verified by the handshake.
+func (c *recordingConn) record(chains [][]*x509.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chains = chains
+}
+
+// ContextWithIdentity return a copy of ctx holding the identity.
+func ContextWithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext return the identity stored by the IdentityMiddleware.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+
+	return id, ok && id != nil
+}
+
+// IdentityFromRequest is a shortcut to IdentityFromContext(r.Context()).
+func IdentityFromRequest(r *http.Request) (*Identity, bool) {
+	return IdentityFromContext(r.Context())
+}
+
+// AsAttrs return the identity as slog attributes.
+func (id *Identity) AsAttrs() []any {
+	if id == nil {
+		return []any{}
+	}
+
+	return []any{
+		slog.String("client cn", id.CommonName),
+		slog.Any("client dns", id.DNSNames),
+		slog.String("client spiffe id", id.SPIFFEID),
+		slog.String("client serial", id.Serial),
+		slog.String("client issuer", id.Issuer),
+		slog.String("client fingerprint", id.Fingerprint),
+		slog.String("level", id.Level.String()),
+		slog.Bool("verified", id.Verified),
+		slog.String("client ca", id.CA),
+	}
+}
+
+func (m *identityMiddleware) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lvl := m.level(r)
+
+		id, ok := NewIdentity(r.TLS, lvl)
+		if chains, recorded := handshakeChains(r.Context()); recorded {
+			id, ok = newIdentity(r.TLS, chains, lvl)
+		}
+
+		if !ok {
+			if m.required {
+				m.log.Warn("mtls request without client certificate", slog.String("remote", r.RemoteAddr))
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if id.Verified {
+			id.CA = m.cas.of(id.Chain)
+		}
+
+		if m.policy != nil {
+			// an unverified certificate (ie. self-signed) can claim any SAN
+			err := ErrNoPeerCertificate
+			if id.Verified {
+				err = m.policy.Authorize(id.Certificate, r.RemoteAddr)
+			}
+
+			if err != nil {
+				m.log.Warn("mtls request rejected", append(id.AsAttrs(), slog.Any("error", err))...)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+				return
+			}
+		}
+
+		m.log.Debug("mtls client identity", id.AsAttrs()...)
+		next.ServeHTTP(w, r.WithContext(ContextWithIdentity(r.Context(), id)))
+	})
+}
+
+// level return the level enforced for the request connection.
+func (m *identityMiddleware) level(r *http.Request) Level {
+	serverName := ""
+	if r.TLS != nil {
+		serverName = r.TLS.ServerName
+	}
+
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+
+	return m.levels.level(serverName, local, stringAddr(r.RemoteAddr))
+}
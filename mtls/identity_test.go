@@ -0,0 +1,172 @@
+package mtls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+// startIdentityServer start an https server answering the json encoded
+// client identity, and return its url.
+func startIdentityServer(t *testing.T, cfg Config, opts ...IdentityOption) string {
+	t.Helper()
+
+	mw, e := IdentityMiddleware(cfg, opts...)
+	require.Nil(t, e)
+
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, e)
+
+	serveTLS(t, ln, cfg, mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := IdentityFromRequest(r)
+		_ = json.NewEncoder(w).Encode(id)
+	})))
+
+	return "https://" + ln.Addr().String()
+}
+
+func fetchIdentity(t *testing.T, url string, client Config) (int, *Identity) {
+	t.Helper()
+
+	c, e := NewHTTPClient(client)
+	require.Nil(t, e)
+
+	resp, e := c.Get(url) //nolint: noctx
+	require.Nil(t, e)
+
+	defer resp.Body.Close()
+
+	var id *Identity
+	if resp.StatusCode == http.StatusOK {
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&id))
+	}
+
+	return resp.StatusCode, id
+}
+
+func TestIdentity(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki            = newTestPKI(t)
+		server, client = pki.configs(t)
+		anonymous      = client
+	)
+
+	anonymous.Cert, anonymous.Key = "", ""
+
+	svc, e := pki.ca.Issue(generate.WithCommonName("svc"), generate.WithDNSNames("svc.local"),
+		generate.WithURIs("https://example.org", "spiffe://example.org/ns/prod/sa/svc"))
+	requirer.Nil(e)
+
+	client.Cert, client.Key = pemOf(t, svc)
+
+	t.Log("identity in the request context")
+	{
+		status, id := fetchIdentity(t, startIdentityServer(t, server), client)
+		requirer.Equal(http.StatusOK, status)
+		requirer.NotNil(id)
+
+		sum := sha256.Sum256(svc.Cert.Raw)
+
+		requirer.Equal("svc", id.CommonName)
+		requirer.Equal([]string{"svc.local"}, id.DNSNames)
+		requirer.Equal("spiffe://example.org/ns/prod/sa/svc", id.SPIFFEID)
+		requirer.Equal(svc.Cert.SerialNumber.Text(16), id.Serial)
+		requirer.Equal("CN=test-ca", id.Issuer)
+		requirer.Equal(hex.EncodeToString(sum[:]), id.Fingerprint)
+		requirer.Equal(RequireAndVerifyClientCert, id.Level)
+		requirer.True(id.Verified)
+		requirer.Contains(id.AsAttrs(), slog.String("client cn", "svc"))
+	}
+
+	t.Log("policy rejection")
+	{
+		quiet := WithIdentityLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+		srv := startIdentityServer(t, server, quiet,
+			WithIdentityPolicy(&Policy{URI: []string{"spiffe://example.org/ns/dev/sa/*"}}))
+		status, _ := fetchIdentity(t, srv, client)
+		requirer.Equal(http.StatusForbidden, status)
+
+		srv = startIdentityServer(t, server, quiet,
+			WithIdentityPolicy(&Policy{URI: []string{"spiffe://example.org/ns/prod/sa/*"}}))
+		status, _ = fetchIdentity(t, srv, client)
+		requirer.Equal(http.StatusOK, status)
+
+		// a self-signed certificate, named after the CA to be sent, claiming
+		// an authorized SAN over an allow listener
+		self, e := generate.NewCA(generate.WithCommonName("test-ca"),
+			generate.WithURIs("spiffe://example.org/ns/prod/sa/svc"))
+		requirer.Nil(e)
+
+		cfg := server
+		cfg.Level = RequireAnyClientCert
+
+		forged := client
+		forged.Cert, forged.Key = pemOf(t, self)
+
+		srv = startIdentityServer(t, cfg, quiet,
+			WithIdentityPolicy(&Policy{URI: []string{"spiffe://example.org/ns/prod/sa/*"}}))
+		status, _ = fetchIdentity(t, srv, forged)
+		requirer.Equal(http.StatusForbidden, status)
+	}
+
+	t.Log("anonymous client and level reported by route")
+	{
+		cfg := server
+		cfg.Level = VerifyClientCertIfGiven
+		cfg.Routes = []Route{{CIDRs: []string{"127.0.0.1"}, Level: ptrLevel(RequestClientCert)}}
+
+		status, id := fetchIdentity(t, startIdentityServer(t, cfg), anonymous)
+		requirer.Equal(http.StatusOK, status)
+		requirer.Nil(id)
+
+		status, _ = fetchIdentity(t, startIdentityServer(t, cfg, WithIdentityRequired(),
+			WithIdentityLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))), anonymous)
+		requirer.Equal(http.StatusUnauthorized, status)
+
+		status, id = fetchIdentity(t, startIdentityServer(t, cfg), client)
+		requirer.Equal(http.StatusOK, status)
+		requirer.Equal(RequestClientCert, id.Level)
+		requirer.False(id.Verified)
+	}
+
+	t.Log("chain verified against the intermediates")
+	{
+		inter, e := pki.ca.NewIntermediate(generate.WithCommonName("test-intermediate"))
+		requirer.Nil(e)
+
+		leaf, e := inter.Issue(generate.WithCommonName("leaf"))
+		requirer.Nil(e)
+
+		key, e := leaf.KeyPEM()
+		requirer.Nil(e)
+
+		cfg := server
+		cfg.Intermediates = string((&generate.Certificate{Cert: inter.Cert}).CertPEM())
+
+		c := client
+		c.Cert, c.Key = string((&generate.Certificate{Cert: leaf.Cert}).CertPEM()), string(key)
+
+		status, id := fetchIdentity(t, startIdentityServer(t, cfg), c)
+		requirer.Equal(http.StatusOK, status)
+		requirer.Equal("leaf", id.CommonName)
+		requirer.True(id.Verified)
+
+		id, ok := NewIdentity(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.Cert}},
+			RequireAndVerifyClientCert)
+		requirer.True(ok)
+		requirer.False(id.Verified, "not verified by crypto/tls, whatever the level")
+	}
+}
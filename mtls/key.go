@@ -0,0 +1,126 @@
+package mtls
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/burgesQ/gommon/mtls/pkcs8"
+)
+
+var (
+	// ErrParseKey error is returned if the Key source doesn't hold a
+	// supported PEM private key.
+	ErrParseKey = errors.New("no supported PEM private key")
+
+	// ErrKeyPassphrase error is returned if the Key is encrypted while no
+	// passphrase is configured.
+	ErrKeyPassphrase = errors.New("encrypted key without passphrase")
+)
+
+// ReadPassphrase return the passphrase of the encrypted Key (or of the
+// PKCS12 bundle), from the Passphrase callback or the KeyPassphrase source
+// (an env:<NAME> variable or any source of the Loader, trailing new lines
+// trimmed).
+func (cfg Config) ReadPassphrase() ([]byte, error) {
+	switch ref := cfg.KeyPassphrase; {
+	case cfg.Passphrase != nil:
+		return cfg.Passphrase()
+
+	case ref == "":
+		return nil, ErrKeyPassphrase
+
+	case strings.HasPrefix(ref, SourceEnv):
+		name := strings.TrimPrefix(ref, SourceEnv)
+
+		v := os.Getenv(name)
+		if v == "" {
+			return nil, fmt.Errorf("passphrase env %q: %w", name, ErrEmptySource)
+		}
+
+		return []byte(v), nil
+
+	default:
+		b, err := cfg.load(ref)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load passphrase [%s]: %w", describeSource(ref), err)
+		}
+
+		return []byte(strings.TrimRight(string(b), "\r\n")), nil
+	}
+}
+
+// plainKey return the PEM key, decrypted if it's an encrypted PKCS #8 key (a
+// legacy encrypted PEM is rejected, see pkcs8.ErrLegacyEncryption).
+func (cfg Config) plainKey(keyPEM []byte) ([]byte, error) {
+	for block, rest := pem.Decode(keyPEM); block != nil; block, rest = pem.Decode(rest) {
+		if !pkcs8.IsEncrypted(block) {
+			continue
+		}
+
+		pass, err := cfg.ReadPassphrase()
+		if err != nil {
+			return nil, fmt.Errorf("decrypting key [%s]: %w", describeSource(cfg.Key), err)
+		}
+
+		plain, err := pkcs8.Decrypt(block, pass)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting key [%s]: %w", describeSource(cfg.Key), err)
+		}
+
+		return pem.EncodeToMemory(plain), nil
+	}
+
+	return keyPEM, nil
+}
+
+// loadPrivateKey load and parse the PEM private key referenced by ref.
+func (cfg Config) loadPrivateKey(ref string) (crypto.Signer, error) {
+	b, err := cfg.load(ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load [%s]: %w", describeSource(ref), err)
+	}
+
+	if b, err = cfg.plainKey(b); err != nil {
+		return nil, err
+	}
+
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+
+		if key, err := parsePrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w in [%s]", ErrParseKey, describeSource(ref))
+}
+
+// parsePrivateKey parse a PKCS#1, SEC 1 or PKCS#8 DER private key.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrParseKey, key)
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,100 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/burgesQ/gommon/mtls/pkcs8"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedKey(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki      = newTestPKI(t)
+		d        = t.TempDir()
+		passFile = filepath.Join(d, "passphrase")
+		base     = Config{
+			Cert: filepath.Join(d, "server.crt"), Key: filepath.Join(d, "server.key"),
+			Ca: string(pki.ca.CertPEM()), Level: RequireAndVerifyClientCert,
+		}
+	)
+
+	requirer.Nil(pki.server.WriteEncryptedFiles(d, "server", []byte("secret")))
+	requirer.Nil(os.WriteFile(passFile, []byte("secret\n"), 0o600))
+	t.Setenv("TEST_MTLS_PASSPHRASE", "secret")
+
+	t.Log("passphrase sources")
+	{
+		for _, src := range []string{"env:TEST_MTLS_PASSPHRASE", passFile, SourceFile + passFile} {
+			cfg := base
+			cfg.KeyPassphrase = src
+
+			_, e := GetTLSCfg(cfg)
+			requirer.Nil(e, src)
+			requirer.Nil(cfg.Validate(), src)
+		}
+
+		cfg := base
+		cfg.KeyPassphrase = "env:TEST_MTLS_UNSET"
+		cfg.Passphrase = func() ([]byte, error) { return []byte("secret"), nil }
+
+		_, e := GetTLSCfg(cfg)
+		requirer.Nil(e, "the callback take precedence")
+	}
+
+	t.Log("missing or wrong passphrase")
+	{
+		_, e := GetTLSCfg(base)
+		requirer.True(errors.Is(e, ErrKeyPassphrase))
+		requirer.True(errors.Is(base.Validate(), ErrKeyPassphrase))
+
+		cfg := base
+		cfg.KeyPassphrase = "env:TEST_MTLS_UNSET"
+
+		_, e = GetTLSCfg(cfg)
+		requirer.True(errors.Is(e, ErrEmptySource))
+
+		cfg.Passphrase = func() ([]byte, error) { return []byte("wrong"), nil }
+
+		_, e = GetTLSCfg(cfg)
+		requirer.True(errors.Is(e, pkcs8.ErrIncorrectPassphrase))
+	}
+
+	t.Log("legacy PEM encryption rejected")
+	{
+		der, e := x509.MarshalECPrivateKey(pki.server.Key.(*ecdsa.PrivateKey))
+		requirer.Nil(e)
+
+		block, e := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, //nolint:staticcheck
+			[]byte("secret"), x509.PEMCipherAES256)
+		requirer.Nil(e)
+
+		cfg := base
+		cfg.Key, cfg.KeyPassphrase = string(pem.EncodeToMemory(block)), passFile
+
+		_, e = GetTLSCfg(cfg)
+		requirer.True(errors.Is(e, pkcs8.ErrLegacyEncryption))
+	}
+
+	t.Log("client certificate")
+	{
+		requirer.Nil(pki.client.WriteEncryptedFiles(d, "client", []byte("secret")))
+
+		client := Config{
+			Cert: filepath.Join(d, "client.crt"), Key: filepath.Join(d, "client.key"),
+			KeyPassphrase: passFile,
+		}
+
+		c, e := GetClientTLSCfg(client)
+		requirer.Nil(e)
+		requirer.Len(c.Certificates, 1)
+	}
+}
@@ -1,12 +1,20 @@
 package mtls
 
 import (
-	"bytes"
 	"crypto/tls"
+	"encoding"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Level is the client authentication level. It is parsed (see Set) from
+// its token (ie. hard), its tls.ClientAuthType name (ie.
+// RequireAndVerifyClientCert), case-insensitively, or its numeric value.
 type Level tls.ClientAuthType
 
 const (
@@ -57,6 +65,15 @@ var (
 		"hardAndSAN": RequireAndVerifyClientCertAndSAN,
 	}
 
+	_lvl2name = map[Level]string{
+		NoClientCert:                     "NoClientCert",
+		RequestClientCert:                "RequestClientCert",
+		RequireAnyClientCert:             "RequireAnyClientCert",
+		VerifyClientCertIfGiven:          "VerifyClientCertIfGiven",
+		RequireAndVerifyClientCert:       "RequireAndVerifyClientCert",
+		RequireAndVerifyClientCertAndSAN: "RequireAndVerifyClientCertAndSAN",
+	}
+
 	_toNatif = map[Level]tls.ClientAuthType{
 		NoClientCert:                     tls.NoClientCert,
 		RequestClientCert:                tls.RequestClientCert,
@@ -65,49 +82,146 @@ var (
 		RequireAndVerifyClientCert:       tls.RequireAndVerifyClientCert,
 		RequireAndVerifyClientCertAndSAN: tls.RequireAndVerifyClientCert,
 	}
+
+	_ flag.Value               = (*Level)(nil)
+	_ encoding.TextMarshaler   = NoClientCert
+	_ encoding.TextUnmarshaler = (*Level)(nil)
+	_ json.Marshaler           = NoClientCert
+	_ json.Unmarshaler         = (*Level)(nil)
+	_ yaml.Marshaler           = NoClientCert
+	_ yaml.Unmarshaler         = (*Level)(nil)
 )
 
+// LevelError is returned for an unknown level.
 type LevelError struct{ what string }
 
 func (e LevelError) Error() string {
-	return fmt.Sprintf("non existing TLS level: %s", e.what)
+	return fmt.Sprintf("non existing TLS level: %s (valid: %s)", e.what, strings.Join(LevelChoices(), ", "))
+}
+
+// LevelChoices return the tokens of the levels, ordered by value.
+func LevelChoices() []string {
+	out := make([]string, 0, len(_lvl2str))
+	for lv := NoClientCert; lv <= RequireAndVerifyClientCertAndSAN; lv++ {
+		out = append(out, _lvl2str[lv])
+	}
+
+	return out
 }
 
+// String return the level token, Level(<n>) for an unknown level.
 func (lv Level) String() string {
 	if v, ok := _lvl2str[lv]; ok {
 		return v
 	}
 
-	return `{}`
+	return "Level(" + strconv.Itoa(int(lv)) + ")"
 }
 
+// GoString return the tls.ClientAuthType name of the level.
+func (lv Level) GoString() string {
+	if v, ok := _lvl2name[lv]; ok {
+		return v
+	}
+
+	return lv.String()
+}
+
+// STD return the crypto/tls ClientAuthType of the level.
 func (lv Level) STD() tls.ClientAuthType {
 	return _toNatif[lv]
 }
 
+// Type implement the pflag.Value interface.
+func (lv *Level) Type() string { return "level" }
+
+// Set parse the level from its token, its tls.ClientAuthType name (both
+// case-insensitive) or its numeric value.
 func (lv *Level) Set(val string) error {
+	val = strings.TrimSpace(val)
+
 	if v, ok := _str2lvl[val]; ok {
 		*lv = v
 
 		return nil
 	}
 
+	for v := NoClientCert; v <= RequireAndVerifyClientCertAndSAN; v++ {
+		if strings.EqualFold(val, _lvl2str[v]) || strings.EqualFold(val, _lvl2name[v]) {
+			*lv = v
+
+			return nil
+		}
+	}
+
+	if n, err := strconv.Atoi(val); err == nil {
+		if _, ok := _lvl2str[Level(n)]; ok {
+			*lv = Level(n)
+
+			return nil
+		}
+	}
+
 	return LevelError{val}
 }
 
+// MarshalText implement encoding.TextMarshaler.
+func (lv Level) MarshalText() ([]byte, error) {
+	v, ok := _lvl2str[lv]
+	if !ok {
+		return nil, LevelError{lv.String()}
+	}
+
+	return []byte(v), nil
+}
+
+// UnmarshalText implement encoding.TextUnmarshaler.
+func (lv *Level) UnmarshalText(b []byte) error {
+	return lv.Set(string(b))
+}
+
+// UnmarshalJSON accept a string or a number.
 func (lv *Level) UnmarshalJSON(b []byte) error {
-	var j string
+	var j any
 	if err := json.Unmarshal(b, &j); err != nil {
 		return err
 	}
 
-	return lv.Set(j)
+	switch v := j.(type) {
+	case string:
+		return lv.Set(v)
+	case float64:
+		return lv.Set(string(b))
+	default:
+		return LevelError{string(b)}
+	}
 }
 
+// MarshalJSON implement json.Marshaler, encoding the level token.
 func (lv Level) MarshalJSON() ([]byte, error) {
-	buffer := bytes.NewBufferString(`"`)
-	buffer.WriteString(lv.String())
-	buffer.WriteString(`"`)
+	b, err := lv.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(string(b))
+}
+
+// UnmarshalYAML implement yaml.Unmarshaler, accepting a string or a number.
+func (lv *Level) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return LevelError{value.Tag}
+	}
+
+	return lv.Set(value.Value)
+}
+
+// MarshalYAML implement yaml.Marshaler.
+func (lv Level) MarshalYAML() (any, error) {
+	b, err := lv.MarshalText()
+	if err != nil {
+		return nil, err
+	}
 
-	return buffer.Bytes(), nil
+	return string(b), nil
 }
@@ -3,9 +3,12 @@ package mtls
 import (
 	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"flag"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestLevel(t *testing.T) {
@@ -20,3 +23,72 @@ func TestLevel(t *testing.T) {
 
 	require.Equal(t, tls.RequireAnyClientCert, lvl.STD())
 }
+
+func TestLevelSet(t *testing.T) {
+	requirer := require.New(t)
+
+	t.Log("tokens, go names, case-insensitive forms and numbers")
+	{
+		for in, want := range map[string]Level{
+			"hardAndSAN":                 RequireAndVerifyClientCertAndSAN,
+			"HARDANDSAN":                 RequireAndVerifyClientCertAndSAN,
+			"RequireAndVerifyClientCert": RequireAndVerifyClientCert,
+			"verifyclientcertifgiven":    VerifyClientCertIfGiven,
+			" Never ":                    NoClientCert,
+			"2":                          RequireAnyClientCert,
+		} {
+			var lvl Level
+
+			requirer.Nil(lvl.Set(in), in)
+			requirer.Equal(want, lvl, in)
+		}
+	}
+
+	t.Log("invalid levels")
+	{
+		var lvl Level
+
+		for _, in := range []string{"", "sometimes", "6", "-1"} {
+			e := lvl.Set(in)
+			requirer.True(errors.As(e, &LevelError{}), in)
+			requirer.Contains(e.Error(), "never, demande, allow, try, hard, hardAndSAN")
+		}
+
+		requirer.Equal("Level(42)", Level(42).String())
+
+		_, e := json.Marshal(Level(42))
+		requirer.NotNil(e)
+	}
+
+	t.Log("text, json, yaml and flag")
+	{
+		var cfg struct {
+			Level Level `json:"level" yaml:"level"`
+		}
+
+		requirer.Nil(json.Unmarshal([]byte(`{"level": 4}`), &cfg))
+		requirer.Equal(RequireAndVerifyClientCert, cfg.Level)
+
+		requirer.Nil(yaml.Unmarshal([]byte("level: RequireAnyClientCert"), &cfg))
+		requirer.Equal(RequireAnyClientCert, cfg.Level)
+
+		requirer.Nil(yaml.Unmarshal([]byte("level: 3"), &cfg))
+		requirer.Equal(VerifyClientCertIfGiven, cfg.Level)
+
+		requirer.NotNil(yaml.Unmarshal([]byte("level: [hard]"), &cfg))
+
+		b, e := yaml.Marshal(cfg)
+		requirer.Nil(e)
+		requirer.Equal("level: try\n", string(b))
+
+		b, e = cfg.Level.MarshalText()
+		requirer.Nil(e)
+		requirer.Equal("try", string(b))
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var(&cfg.Level, "level", "client authentication level")
+		requirer.Nil(fs.Parse([]string{"-level", "HARD"}))
+		requirer.Equal(RequireAndVerifyClientCert, cfg.Level)
+		requirer.Equal("level", cfg.Level.Type())
+	}
+}
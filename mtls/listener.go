@@ -2,16 +2,105 @@ package mtls
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 )
 
+// Networks supported by LoadListner.
+const (
+	NetworkTCP  = "tcp"
+	NetworkTCP4 = "tcp4"
+	NetworkTCP6 = "tcp6"
+	NetworkUnix = "unix"
+)
+
+// ErrNetwork error is returned for an unsupported listener network.
+var ErrNetwork = errors.New("unsupported network")
+
+type (
+	// ListenerOption customize LoadListner.
+	ListenerOption func(*listenerOptions)
+
+	listenerOptions struct {
+		network    string
+		socketMode os.FileMode
+	}
+)
+
+// WithNetwork set the network to listen on: tcp (dual-stack), tcp4, tcp6
+// or unix. Default to tcp4; an empty network keep the default.
+func WithNetwork(network string) ListenerOption {
+	return func(o *listenerOptions) {
+		if network != "" {
+			o.network = network
+		}
+	}
+}
+
+// WithSocketMode set the permissions of the unix socket.
+func WithSocketMode(mode os.FileMode) ListenerOption {
+	return func(o *listenerOptions) { o.socketMode = mode }
+}
+
 // LoadTLSListener return a tls listner ready for mTLS and/or http2.
-func LoadListner(addr string, cfg *tls.Config) (net.Listener, error) {
-	listner, e := net.Listen("tcp4", addr)
+// The addr is a path for the unix network.
+func LoadListner(addr string, cfg *tls.Config, opts ...ListenerOption) (net.Listener, error) {
+	o := listenerOptions{network: NetworkTCP4}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	switch o.network {
+	case NetworkTCP, NetworkTCP4, NetworkTCP6, NetworkUnix:
+	default:
+		return nil, fmt.Errorf("%w: %q (tcp, tcp4, tcp6 or unix)", ErrNetwork, o.network)
+	}
+
+	listner, e := net.Listen(o.network, addr)
 	if e != nil {
 		return nil, fmt.Errorf("creating tls listner: %w", e)
 	}
 
-	return tls.NewListener(listner, cfg), nil
+	if o.network == NetworkUnix && o.socketMode != 0 {
+		if e := os.Chmod(addr, o.socketMode); e != nil {
+			_ = listner.Close()
+
+			return nil, fmt.Errorf("setting the socket mode: %w", e)
+		}
+	}
+
+	return WrapListener(listner, cfg), nil
+}
+
+// WrapListener return a tls listner accepting the connections of an
+// existing listener (ie. inherited via systemd socket activation). The
+// chains verified by the handshakes are recorded (see ConnContext).
+func WrapListener(listner net.Listener, cfg *tls.Config) net.Listener {
+	return tls.NewListener(&recordingListener{Listener: listner}, cfg)
+}
+
+// Listen return a tls listner serving the cfg (see GetTLSCfg) on its
+// Network, reporting the handshakes to its Observer.
+func Listen(addr string, cfg Config, http2 ...bool) (net.Listener, error) {
+	tlsCfg, err := GetTLSCfg(cfg, http2...)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := LoadListner(addr, tlsCfg, WithNetwork(cfg.Network))
+	if err != nil {
+		return nil, err
+	}
+
+	observed, err := ObserveListener(ln, cfg)
+	if err != nil {
+		_ = ln.Close()
+
+		return nil, err
+	}
+
+	return observed, nil
 }
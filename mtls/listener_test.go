@@ -0,0 +1,108 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// echoCN accept a connection and answer the client certificate CN, if
+// any.
+func echoCN(ln net.Listener) {
+	conn, e := ln.Accept()
+	if e != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	tc, _ := conn.(*tls.Conn)
+	if tc.Handshake() != nil || len(tc.ConnectionState().PeerCertificates) == 0 {
+		return
+	}
+
+	_, _ = io.WriteString(conn, tc.ConnectionState().PeerCertificates[0].Subject.CommonName)
+}
+
+func TestListener(t *testing.T) {
+	requirer := require.New(t)
+
+	server, client := newTestPKI(t).configs(t)
+
+	tlsCfg, e := GetTLSCfg(server)
+	requirer.Nil(e)
+
+	clientCfg, e := GetClientTLSCfg(client)
+	requirer.Nil(e)
+
+	t.Log("default and dual-stack tcp")
+	{
+		for _, network := range []string{"", NetworkTCP} {
+			ln, e := LoadListner("127.0.0.1:0", tlsCfg, WithNetwork(network))
+			requirer.Nil(e)
+
+			go echoCN(ln)
+			_, e = dialTLS(t, "tcp", ln.Addr().String(), clientCfg)
+			requirer.Nil(e)
+			requirer.Nil(ln.Close())
+		}
+	}
+
+	t.Log("tcp6")
+	{
+		cfg := server
+		cfg.Network = NetworkTCP6
+
+		ln, e := Listen("[::1]:0", cfg)
+		if e != nil {
+			t.Log("no ipv6 support:", e)
+		} else {
+			go echoCN(ln)
+			_, e = dialTLS(t, "tcp6", ln.Addr().String(), clientCfg)
+			requirer.Nil(e)
+			requirer.Nil(ln.Close())
+		}
+	}
+
+	t.Log("unix socket")
+	{
+		sock := filepath.Join(t.TempDir(), "mtls.sock")
+
+		ln, e := LoadListner(sock, tlsCfg, WithNetwork(NetworkUnix), WithSocketMode(0o600))
+		requirer.Nil(e)
+
+		fi, e := os.Stat(sock)
+		requirer.Nil(e)
+		requirer.Equal(os.FileMode(0o600), fi.Mode().Perm())
+
+		go echoCN(ln)
+		_, e = dialTLS(t, "unix", sock, clientCfg)
+		requirer.Nil(e)
+		requirer.Nil(ln.Close())
+	}
+
+	t.Log("wrap an existing listener")
+	{
+		raw, e := net.Listen("tcp", "127.0.0.1:0")
+		requirer.Nil(e)
+
+		ln := WrapListener(raw, tlsCfg)
+
+		go echoCN(ln)
+		_, e = dialTLS(t, "tcp", ln.Addr().String(), clientCfg)
+		requirer.Nil(e)
+		requirer.Nil(ln.Close())
+	}
+
+	t.Log("unsupported network")
+	{
+		_, e := LoadListner("127.0.0.1:0", tlsCfg, WithNetwork("udp"))
+		requirer.True(errors.Is(e, ErrNetwork))
+	}
+}
@@ -0,0 +1,287 @@
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Metrics set through the HandshakeMetrics interface, labeled by result,
+// reason, level and version.
+const (
+	MetricHandshakes        = "mtls_handshakes_total"
+	MetricHandshakeDuration = "mtls_handshake_duration_seconds"
+)
+
+// Categories of handshake failures.
+const (
+	FailureNone          FailureReason = ""
+	FailureNoCertificate FailureReason = "no_certificate"
+	FailureUntrusted     FailureReason = "untrusted"
+	FailureExpired       FailureReason = "expired"
+	FailureInvalid       FailureReason = "invalid_certificate"
+	FailureRevoked       FailureReason = "revoked"
+	FailurePolicy        FailureReason = "policy"
+	FailureProtocol      FailureReason = "protocol"
+	FailureNetwork       FailureReason = "network"
+	FailureOther         FailureReason = "other"
+)
+
+type (
+	// FailureReason categorize a handshake failure.
+	FailureReason string
+
+	// HandshakeEvent describe a server side handshake.
+	HandshakeEvent struct {
+		RemoteAddr string
+		LocalAddr  string
+		ServerName string
+		// Version, CipherSuite and ALPN are the negotiated parameters.
+		Version     string
+		CipherSuite string
+		ALPN        string
+		// ClientSubject is the subject of the client certificate, if any.
+		ClientSubject string
+		// Level is the authentication level enforced for the connection.
+		Level    Level
+		Duration time.Duration
+		// Err is the handshake error, Reason its category.
+		Err    error
+		Reason FailureReason
+	}
+
+	// Observer receive the handshake events.
+	Observer interface {
+		ObserveHandshake(HandshakeEvent)
+	}
+
+	// ObserverFunc is a func implementing Observer.
+	ObserverFunc func(HandshakeEvent)
+
+	// HandshakeMetrics receive the handshake counters and histograms, ie.
+	// backed by prometheus CounterVec and HistogramVec.
+	HandshakeMetrics interface {
+		IncCounter(name string, labels map[string]string)
+		ObserveHistogram(name string, value float64, labels map[string]string)
+	}
+
+	multiObserver []Observer
+
+	slogObserver struct{ log *slog.Logger }
+
+	metricsObserver struct{ m HandshakeMetrics }
+
+	// observedListener report the handshake of the accepted connections.
+	observedListener struct {
+		net.Listener
+		obs     Observer
+		level   func(serverName string, local, remote net.Addr) Level
+		timeout time.Duration
+	}
+)
+
+// ObserveHandshake implement Observer.
+func (f ObserverFunc) ObserveHandshake(e HandshakeEvent) { f(e) }
+
+// MultiObserver return an Observer forwarding the events to each observer.
+func MultiObserver(obs ...Observer) Observer { return multiObserver(obs) }
+
+func (m multiObserver) ObserveHandshake(e HandshakeEvent) {
+	for _, o := range m {
+		o.ObserveHandshake(e)
+	}
+}
+
+// NewSlogObserver return an Observer logging the successful handshakes at
+// the debug level and the failed ones at the warn level.
+func NewSlogObserver(l *slog.Logger) Observer {
+	if l == nil {
+		l = slog.Default()
+	}
+
+	return slogObserver{log: l}
+}
+
+func (o slogObserver) ObserveHandshake(e HandshakeEvent) {
+	if e.Err != nil {
+		o.log.Warn("mtls handshake failed", e.AsAttrs()...)
+
+		return
+	}
+
+	o.log.Debug("mtls handshake", e.AsAttrs()...)
+}
+
+// NewMetricsObserver return an Observer counting the handshakes and
+// observing their duration.
+func NewMetricsObserver(m HandshakeMetrics) Observer { return metricsObserver{m: m} }
+
+func (o metricsObserver) ObserveHandshake(e HandshakeEvent) {
+	result := "ok"
+	if e.Err != nil {
+		result = "failed"
+	}
+
+	labels := map[string]string{
+		"result":  result,
+		"reason":  string(e.Reason),
+		"level":   e.Level.String(),
+		"version": e.Version,
+	}
+
+	o.m.IncCounter(MetricHandshakes, labels)
+	o.m.ObserveHistogram(MetricHandshakeDuration, e.Duration.Seconds(), labels)
+}
+
+// AsAttrs return the event as slog attributes.
+func (e HandshakeEvent) AsAttrs() []any {
+	attrs := []any{
+		slog.String("remote", e.RemoteAddr),
+		slog.String("local", e.LocalAddr),
+		slog.String("sni", e.ServerName),
+		slog.String("version", e.Version),
+		slog.String("cipher", e.CipherSuite),
+		slog.String("alpn", e.ALPN),
+		slog.String("client", e.ClientSubject),
+		slog.String("level", e.Level.String()),
+		slog.Duration("duration", e.Duration),
+	}
+
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("reason", string(e.Reason)), slog.Any("error", e.Err))
+	}
+
+	return attrs
+}
+
+// ObserveListener return a tls listener reporting the handshake of each
+// accepted connection to the config Observer. The handshake is started as
+// soon as the connection is accepted and bounded by the config
+// HandshakeTimeout. The ln must return *tls.Conn (see LoadListner).
+func ObserveListener(ln net.Listener, cfg Config) (net.Listener, error) {
+	if cfg.Observer == nil {
+		return ln, nil
+	}
+
+	levels, err := newLevelResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return observeListener(ln, cfg.Observer, levels.level, cfg.HandshakeTimeout), nil
+}
+
+// observeListener return a listener reporting the handshakes to obs, the
+// level of each connection being resolved by level. A zero timeout doesn't
+// bound the handshakes.
+func observeListener(ln net.Listener, obs Observer,
+	level func(serverName string, local, remote net.Addr) Level, timeout time.Duration,
+) net.Listener {
+	if obs == nil {
+		return ln
+	}
+
+	return &observedListener{Listener: ln, obs: obs, level: level, timeout: timeout}
+}
+
+func (l *observedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		// concurrent handshakes are serialized, the caller get the result
+		// of this one
+		go l.observe(tc, time.Now())
+	}
+
+	return conn, nil
+}
+
+func (l *observedListener) observe(conn *tls.Conn, start time.Time) {
+	ctx := context.Background()
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+
+	err := conn.HandshakeContext(ctx)
+	cs := conn.ConnectionState()
+	lvl := l.level(cs.ServerName, conn.LocalAddr(), conn.RemoteAddr())
+
+	e := HandshakeEvent{
+		RemoteAddr: conn.RemoteAddr().String(),
+		LocalAddr:  conn.LocalAddr().String(),
+		ServerName: cs.ServerName,
+		ALPN:       cs.NegotiatedProtocol,
+		Level:      lvl,
+		Duration:   time.Since(start),
+		Err:        err,
+		Reason:     failureReason(err, cs, lvl),
+	}
+
+	if cs.HandshakeComplete {
+		e.Version, e.CipherSuite = tls.VersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite)
+	}
+
+	if len(cs.PeerCertificates) > 0 {
+		e.ClientSubject = cs.PeerCertificates[0].Subject.String()
+	}
+
+	l.obs.ObserveHandshake(e)
+}
+
+// failureReason categorize the handshake error. The errors crypto/tls
+// doesn't type are categorized from the state the handshake reached: no
+// cipher suite negotiated is a protocol failure, no certificate sent while
+// the level require one a missing certificate.
+func failureReason(err error, cs tls.ConnectionState, lvl Level) FailureReason {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalid          x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		revoked          RevokedError
+		policy           PolicyError
+		netErr           net.Error
+		alert            tls.AlertError
+		header           tls.RecordHeaderError
+	)
+
+	switch {
+	case err == nil:
+		return FailureNone
+	case errors.As(err, &unknownAuthority):
+		return FailureUntrusted
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return FailureExpired
+	case errors.As(err, &invalid), errors.As(err, &hostname):
+		return FailureInvalid
+	case errors.As(err, &revoked):
+		return FailureRevoked
+	case errors.As(err, &policy):
+		return FailurePolicy
+	case errors.Is(err, ErrNoPeerCertificate):
+		return FailureNoCertificate
+	case errors.Is(err, io.EOF), errors.As(err, &netErr):
+		return FailureNetwork
+	case errors.As(err, &alert), errors.As(err, &header), cs.CipherSuite == 0:
+		return FailureProtocol
+	case len(cs.PeerCertificates) == 0 && requireClientCert(lvl):
+		return FailureNoCertificate
+	default:
+		return FailureOther
+	}
+}
+
+// requireClientCert return true if the level require a client certificate.
+func requireClientCert(lvl Level) bool {
+	return lvl == RequireAnyClientCert || lvl >= RequireAndVerifyClientCert
+}
@@ -0,0 +1,189 @@
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+type testHandshakeMetrics struct {
+	counters map[string]int
+	mu       sync.Mutex
+}
+
+func (m *testHandshakeMetrics) IncCounter(name string, labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counters[name+"/"+labels["result"]+"/"+labels["reason"]]++
+}
+
+func (m *testHandshakeMetrics) ObserveHistogram(string, float64, map[string]string) {}
+
+func (m *testHandshakeMetrics) count(key string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counters[key]
+}
+
+func TestObserver(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki            = newTestPKI(t)
+		server, client = pki.configs(t)
+		events         = make(chan HandshakeEvent, 10)
+		metrics        = &testHandshakeMetrics{counters: map[string]int{}}
+	)
+
+	server.Level = RequireAndVerifyClientCertAndSAN
+	server.Policy = &Policy{CN: []string{"admin"}}
+	server.Observer = MultiObserver(
+		ObserverFunc(func(e HandshakeEvent) { events <- e }),
+		NewMetricsObserver(metrics))
+
+	ln, e := Listen("127.0.0.1:0", server, true)
+	requirer.Nil(e)
+
+	srv := &http.Server{ //nolint:gosec
+		Handler:  http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+
+	go func() { _ = srv.Serve(ln) }()
+
+	t.Cleanup(func() { _ = srv.Close() })
+
+	next := func() HandshakeEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			requirer.FailNow("no handshake event")
+		}
+
+		return HandshakeEvent{}
+	}
+
+	addr := ln.Addr().String()
+
+	t.Log("rejected by the policy")
+	{
+		_, e := dialCN(t, addr, client, "localhost")
+		requirer.NotNil(e)
+
+		ev := next()
+		requirer.NotNil(ev.Err)
+		requirer.Equal(FailurePolicy, ev.Reason)
+		requirer.Equal("CN=client", ev.ClientSubject)
+		requirer.Equal("localhost", ev.ServerName)
+		requirer.Equal(RequireAndVerifyClientCertAndSAN, ev.Level)
+		requirer.Equal(addr, ev.LocalAddr)
+	}
+
+	t.Log("failure categories")
+	{
+		other, e := generate.NewCA(generate.WithCommonName("other-ca"))
+		requirer.Nil(e)
+
+		untrusted, e := other.Issue(generate.WithCommonName("admin"))
+		requirer.Nil(e)
+
+		anonymous := client
+		anonymous.Cert, anonymous.Key = "", ""
+
+		_, e = dialCN(t, addr, anonymous, "localhost")
+		requirer.NotNil(e)
+		requirer.Equal(FailureNoCertificate, next().Reason)
+
+		old := client
+		old.TLS = &TLSOptions{Profile: ProfileLegacy, MaxVersion: "1.1"}
+
+		_, e = dialCN(t, addr, old, "localhost")
+		requirer.NotNil(e)
+		requirer.Equal(FailureProtocol, next().Reason)
+
+		// the client skip the certificates not issued by the requested CAs
+		cert, key := pemOf(t, untrusted)
+		pair, e := tls.X509KeyPair([]byte(cert), []byte(key))
+		requirer.Nil(e)
+
+		tlsCfg, e := GetClientTLSCfg(client)
+		requirer.Nil(e)
+
+		tlsCfg.ServerName, tlsCfg.Certificates = "localhost", nil
+		tlsCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &pair, nil }
+
+		if conn, e := tls.Dial("tcp", addr, tlsCfg); e == nil {
+			_, _ = conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+			_, e = conn.Read(make([]byte, 1))
+			requirer.NotNil(e)
+			_ = conn.Close()
+		}
+
+		requirer.Equal(FailureUntrusted, next().Reason)
+	}
+
+	t.Log("successful handshake")
+	{
+		admin, e := pki.ca.Issue(generate.WithCommonName("admin"))
+		requirer.Nil(e)
+
+		c := client
+		c.Cert, c.Key = pemOf(t, admin)
+
+		_, e = dialCN(t, addr, c, "localhost")
+		requirer.Nil(e)
+
+		ev := next()
+		requirer.Nil(ev.Err)
+		requirer.Equal(FailureNone, ev.Reason)
+		requirer.Equal("TLS 1.3", ev.Version)
+		requirer.NotEmpty(ev.CipherSuite)
+		requirer.Equal("CN=admin", ev.ClientSubject)
+		requirer.Positive(ev.Duration)
+	}
+
+	t.Log("handshake timeout")
+	{
+		cfg := server
+		cfg.Observer, cfg.HandshakeTimeout = ObserverFunc(func(e HandshakeEvent) { events <- e }), 50*time.Millisecond
+
+		ln, e := Listen("127.0.0.1:0", cfg, true)
+		requirer.Nil(e)
+
+		srv := &http.Server{ //nolint:gosec
+			Handler:  http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}),
+			ErrorLog: log.New(io.Discard, "", 0),
+		}
+
+		go func() { _ = srv.Serve(ln) }()
+
+		t.Cleanup(func() { _ = srv.Close() })
+
+		// a silent client
+		conn, e := net.Dial("tcp", ln.Addr().String())
+		requirer.Nil(e)
+
+		defer conn.Close()
+
+		ev := next()
+		requirer.True(errors.Is(ev.Err, context.DeadlineExceeded), ev.Err)
+		requirer.Equal(FailureNetwork, ev.Reason)
+	}
+
+	requirer.Equal(1, metrics.count(MetricHandshakes+"/ok/"))
+	requirer.Equal(1, metrics.count(MetricHandshakes+"/failed/policy"))
+	requirer.Equal(1, metrics.count(MetricHandshakes+"/failed/untrusted"))
+}
@@ -0,0 +1,70 @@
+package mtls
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ErrPKCS12 error is returned for an invalid PKCS #12 bundle or settings.
+var ErrPKCS12 = errors.New("invalid pkcs12 bundle")
+
+// loadPKCS12 load and decode the PKCS #12 bundle, its password being the
+// Key passphrase (empty if none is set). It return the certificate
+// followed by its chain and the private key.
+func (cfg Config) loadPKCS12() ([]*x509.Certificate, crypto.Signer, error) {
+	b, err := cfg.load(cfg.PKCS12)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load pkcs12 [%s]: %w", describeSource(cfg.PKCS12), err)
+	}
+
+	var password []byte
+	if cfg.Passphrase != nil || cfg.KeyPassphrase != "" {
+		if password, err = cfg.ReadPassphrase(); err != nil {
+			return nil, nil, fmt.Errorf("decoding pkcs12 [%s]: %w", describeSource(cfg.PKCS12), err)
+		}
+	}
+
+	key, cert, chain, err := pkcs12.DecodeChain(b, string(password))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w [%s]: %w", ErrPKCS12, describeSource(cfg.PKCS12), err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w [%s]: unsupported key %T", ErrPKCS12, describeSource(cfg.PKCS12), key)
+	}
+
+	return append([]*x509.Certificate{cert}, chain...), signer, nil
+}
+
+// readPKCS12 set the cert (followed by its chain) and the key from the
+// PKCS #12 bundle of the config.
+func (raw *rawMaterial) readPKCS12(cfg Config) error {
+	if cfg.Cert != "" || cfg.Key != "" {
+		return fmt.Errorf("%w: pkcs12 and cert / key are exclusive", ErrPKCS12)
+	}
+
+	chain, key, err := cfg.loadPKCS12()
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("%w [%s]: %w", ErrPKCS12, describeSource(cfg.PKCS12), err)
+	}
+
+	raw.cert = nil
+	for _, c := range chain {
+		raw.cert = append(raw.cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+
+	raw.key = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	return nil
+}
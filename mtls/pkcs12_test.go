@@ -0,0 +1,75 @@
+package mtls
+
+import (
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPKCS12(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki       = newTestPKI(t)
+		d         = t.TempDir()
+		ca        = string(pki.ca.CertPEM())
+		serverP12 = filepath.Join(d, "server.p12")
+	)
+
+	requirer.Nil(pki.server.WritePKCS12File(d, "server", "secret"))
+	t.Setenv("TEST_MTLS_P12_PASSWORD", "secret")
+
+	clientPFX, e := pki.client.PKCS12("", true)
+	requirer.Nil(e)
+
+	server := Config{
+		PKCS12: serverP12, KeyPassphrase: "env:TEST_MTLS_P12_PASSWORD",
+		Ca: ca, Level: RequireAndVerifyClientCert,
+	}
+	client := Config{
+		PKCS12: SourceBase64 + base64.StdEncoding.EncodeToString(clientPFX),
+		Ca:     ca, ServerName: "localhost",
+	}
+
+	t.Log("validate and inspect")
+	{
+		requirer.Nil(server.Validate())
+		requirer.Nil(client.Validate())
+
+		infos, e := Inspect(server)
+		requirer.Nil(e)
+		requirer.Equal("CN=server", infos[0].Subject)
+	}
+
+	t.Log("mTLS round trip")
+	{
+		srv := startTestServer(t, server)
+
+		c, e := NewHTTPClient(client)
+		requirer.Nil(e)
+
+		body, e := get(t, c, srv.URL)
+		requirer.Nil(e)
+		requirer.Equal("HTTP/1.1 client", body)
+	}
+
+	t.Log("invalid bundles")
+	{
+		cfg := server
+		cfg.KeyPassphrase = ""
+
+		_, e := GetTLSCfg(cfg)
+		requirer.True(errors.Is(e, ErrPKCS12))
+		requirer.True(errors.Is(cfg.Validate(), ErrPKCS12))
+
+		cfg = server
+		cfg.Cert = serverP12
+
+		_, e = GetTLSCfg(cfg)
+		requirer.True(errors.Is(e, ErrPKCS12))
+		requirer.True(errors.Is(cfg.Validate(), ErrPKCS12))
+	}
+}
@@ -0,0 +1,216 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+// ErrPolicyPattern error is returned for an invalid Policy entry.
+var ErrPolicyPattern = errors.New("invalid policy pattern")
+
+// Rule name a Policy rule.
+type Rule string
+
+const (
+	RuleDNS        Rule = "dns"
+	RuleURI        Rule = "uri"
+	RuleEmail      Rule = "email"
+	RuleIP         Rule = "ip"
+	RuleCN         Rule = "cn"
+	RuleOU         Rule = "ou"
+	RuleRemoteAddr Rule = "remote_addr"
+)
+
+type (
+	// Policy hold the authorization rules applied to the verified client
+	// certificate when the RequireAndVerifyClientCertAndSAN level is used.
+	//
+	// Each non empty rule must be satisfied; a rule is satisfied if one of
+	// the certificate values match one of the rule entries. The URI, Email,
+	// CN and OU entries are path.Match patterns (ie.
+	// `spiffe://domain/ns/*/sa/*`), the IP entries are addresses or CIDRs.
+	//
+	// The DNS entries follow RFC 6125: the wildcard is only allowed in the
+	// left-most label and never span a dot (`*.svc.local` match
+	// `api.svc.local` but neither `a.b.svc.local` nor `svc.local`), the
+	// names are compared case-insensitively.
+	//
+	// A nil Policy only enforce the RemoteAddr rule.
+	Policy struct {
+		// DNS is the allow-list of DNS SANs.
+		DNS []string `json:"dns"         mapstructure:"dns"`
+		// URI is the allow-list of URI SANs (ie. SPIFFE IDs).
+		URI []string `json:"uri"         mapstructure:"uri"`
+		// Email is the allow-list of e-mail SANs.
+		Email []string `json:"email"       mapstructure:"email"`
+		// IP is the allow-list of IP SANs, as addresses or CIDRs.
+		IP []string `json:"ip"          mapstructure:"ip"`
+		// CN is the allow-list of subject common names.
+		CN []string `json:"cn"          mapstructure:"cn"`
+		// OU is the allow-list of subject organizational units.
+		OU []string `json:"ou"          mapstructure:"ou"`
+		// RemoteAddr require a SAN matching the peer remote address.
+		RemoteAddr bool `json:"remote_addr" mapstructure:"remote_addr"`
+	}
+
+	// PolicyError is returned when a rule of the Policy reject the peer.
+	PolicyError struct {
+		Rule    Rule
+		Subject string
+	}
+)
+
+func (e PolicyError) Error() string {
+	return fmt.Sprintf("peer certificate %q rejected by the %s rule", e.Subject, e.Rule)
+}
+
+// Validate ensure the rules entries are valid patterns and CIDRs.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return nil
+	}
+
+	for _, patterns := range [][]string{p.DNS, p.URI, p.Email, p.CN, p.OU} {
+		for _, pattern := range patterns {
+			if _, e := path.Match(pattern, ""); e != nil {
+				return fmt.Errorf("%w %q: %w", ErrPolicyPattern, pattern, e)
+			}
+		}
+	}
+
+	for _, pattern := range p.DNS {
+		if _, rest, _ := strings.Cut(pattern, "."); strings.ContainsAny(rest, "*?[") {
+			return fmt.Errorf("%w %q: the wildcard is only allowed in the left-most label", ErrPolicyPattern, pattern)
+		}
+	}
+
+	for _, ip := range p.IP {
+		if _, e := parsePrefix(ip); e != nil {
+			return fmt.Errorf("invalid policy ip %q: %w", ip, e)
+		}
+	}
+
+	return nil
+}
+
+// Authorize check the peer leaf certificate against the policy.
+// The remoteAddr is only used by the RemoteAddr rule.
+func (p *Policy) Authorize(leaf *x509.Certificate, remoteAddr string) error {
+	if p == nil {
+		p = &Policy{RemoteAddr: true}
+	}
+
+	reject := func(r Rule) error {
+		return PolicyError{Rule: r, Subject: leaf.Subject.String()}
+	}
+
+	uris := make([]string, 0, len(leaf.URIs))
+	for _, u := range leaf.URIs {
+		uris = append(uris, u.String())
+	}
+
+	for _, rule := range []struct {
+		name    Rule
+		allowed []string
+		values  []string
+	}{
+		{RuleURI, p.URI, uris},
+		{RuleEmail, p.Email, leaf.EmailAddresses},
+		{RuleCN, p.CN, []string{leaf.Subject.CommonName}},
+		{RuleOU, p.OU, leaf.Subject.OrganizationalUnit},
+	} {
+		if len(rule.allowed) > 0 && !matchAny(rule.allowed, rule.values) {
+			return reject(rule.name)
+		}
+	}
+
+	if len(p.DNS) > 0 && !matchDNS(p.DNS, leaf.DNSNames) {
+		return reject(RuleDNS)
+	}
+
+	if len(p.IP) > 0 && !matchIP(p.IP, leaf.IPAddresses) {
+		return reject(RuleIP)
+	}
+
+	if p.RemoteAddr {
+		host, _, e := net.SplitHostPort(remoteAddr)
+		if e != nil {
+			host = remoteAddr
+		}
+
+		if leaf.VerifyHostname(host) != nil {
+			return reject(RuleRemoteAddr)
+		}
+	}
+
+	return nil
+}
+
+func matchAny(patterns, values []string) bool {
+	for _, pattern := range patterns {
+		for _, v := range values {
+			if ok, _ := path.Match(pattern, v); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchDNS match the names against the RFC 6125 patterns: the left-most
+// label may hold a wildcard, the other labels must be equal.
+func matchDNS(patterns, names []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		patternLabel, patternRest, _ := strings.Cut(pattern, ".")
+
+		for _, name := range names {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			label, rest, _ := strings.Cut(name, ".")
+
+			if rest != patternRest || label == "" {
+				continue
+			}
+
+			if ok, _ := path.Match(patternLabel, label); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func matchIP(allowed []string, ips []net.IP) bool {
+	for _, a := range allowed {
+		prefix, e := parsePrefix(a)
+		if e != nil {
+			continue
+		}
+
+		for _, ip := range ips {
+			if addr, ok := netip.AddrFromSlice(ip); ok && prefix.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parsePrefix parse a CIDR or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, e := netip.ParseAddr(s); e == nil {
+		addr = addr.Unmap()
+
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	return netip.ParsePrefix(s)
+}
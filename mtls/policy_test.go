@@ -0,0 +1,107 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	requirer := require.New(t)
+
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/api")
+	leaf := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "api",
+			OrganizationalUnit: []string{"backend"},
+		},
+		DNSNames:       []string{"api.svc.local"},
+		EmailAddresses: []string{"ops@example.org"},
+		IPAddresses:    []net.IP{net.ParseIP("10.1.2.3")},
+		URIs:           []*url.URL{spiffe},
+	}
+
+	rejectedBy := func(e error) Rule {
+		t.Helper()
+
+		var pe PolicyError
+
+		requirer.True(errors.As(e, &pe), "expected a PolicyError, got %v", e)
+
+		return pe.Rule
+	}
+
+	t.Log("nil policy only check the remote address")
+	{
+		var p *Policy
+
+		requirer.Nil(p.Validate())
+		requirer.Nil(p.Authorize(leaf, "10.1.2.3:4242"))
+		requirer.Equal(RuleRemoteAddr, rejectedBy(p.Authorize(leaf, "192.168.0.1:4242")))
+	}
+
+	t.Log("empty policy authorize any verified peer")
+	{
+		requirer.Nil((&Policy{}).Authorize(leaf, "192.168.0.1:4242"))
+	}
+
+	t.Log("matching rules")
+	{
+		p := &Policy{
+			DNS:   []string{"*.svc.local"},
+			URI:   []string{"spiffe://example.org/ns/*/sa/api"},
+			Email: []string{"*@example.org"},
+			IP:    []string{"10.0.0.0/8"},
+			CN:    []string{"api"},
+			OU:    []string{"front", "backend"},
+		}
+
+		requirer.Nil(p.Validate())
+		requirer.Nil(p.Authorize(leaf, "192.168.0.1:4242"))
+	}
+
+	t.Log("rejecting rules")
+	{
+		for rule, p := range map[Rule]*Policy{
+			RuleDNS:        {DNS: []string{"*.other.local"}},
+			RuleURI:        {URI: []string{"spiffe://example.org/ns/dev/*"}},
+			RuleEmail:      {Email: []string{"root@example.org"}},
+			RuleIP:         {IP: []string{"10.1.2.4", "172.16.0.0/12"}},
+			RuleCN:         {CN: []string{"web*"}},
+			RuleOU:         {OU: []string{"front"}},
+			RuleRemoteAddr: {DNS: []string{"*.svc.local"}, RemoteAddr: true},
+		} {
+			requirer.Equal(rule, rejectedBy(p.Authorize(leaf, "[::1]:4242")))
+		}
+	}
+
+	t.Log("dns wildcards only span the left-most label")
+	{
+		for pattern, match := range map[string]bool{
+			"API.svc.local.":  true,
+			"a*.svc.local":    true,
+			"*.svc.local":     true,
+			"*.local":         false,
+			"*.api.svc.local": false,
+			"svc.local":       false,
+		} {
+			requirer.Equal(match, (&Policy{DNS: []string{pattern}}).Authorize(leaf, "") == nil, pattern)
+		}
+
+		evil := &x509.Certificate{DNSNames: []string{"api.evil.com"}}
+		requirer.Equal(RuleDNS, rejectedBy((&Policy{DNS: []string{"api.*"}}).Authorize(evil, "")))
+	}
+
+	t.Log("invalid entries")
+	{
+		requirer.True(errors.Is((&Policy{DNS: []string{"api.*"}}).Validate(), ErrPolicyPattern))
+		requirer.True(errors.Is((&Policy{DNS: []string{"*.*.local"}}).Validate(), ErrPolicyPattern))
+		requirer.NotNil((&Policy{DNS: []string{"[a-"}}).Validate())
+		requirer.NotNil((&Policy{IP: []string{"10.0.0.0/42"}}).Validate())
+	}
+}
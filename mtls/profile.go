@@ -0,0 +1,255 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TLS profiles, selectable via TLSOptions.Profile.
+const (
+	// ProfileModern only accept TLS 1.3.
+	ProfileModern Profile = "modern"
+	// ProfileIntermediate accept TLS 1.2 (forward secret AEAD ciphers only)
+	// and TLS 1.3. It's the default profile.
+	ProfileIntermediate Profile = "intermediate"
+	// ProfileLegacy accept TLS 1.0 up to TLS 1.3, with the forward secret
+	// CBC ciphers.
+	ProfileLegacy Profile = "legacy"
+	// ProfileFIPS restrict the curves and ciphers to the FIPS 140 approved
+	// ones (NIST curves, AES-GCM).
+	ProfileFIPS Profile = "fips"
+)
+
+// ErrTLSOptions error is returned for invalid TLSOptions.
+var ErrTLSOptions = errors.New("invalid tls options")
+
+type (
+	// Profile is the name of a set of TLS versions, curves and ciphers.
+	Profile string
+
+	// TLSOptions select the TLS profile and override its settings.
+	TLSOptions struct {
+		// Profile is the base profile, default to ProfileIntermediate.
+		Profile Profile `json:"profile" mapstructure:"profile"`
+		// MinVersion override the minimal TLS version (ie. "1.2"). TLS 1.0
+		// and 1.1 are only accepted through ProfileLegacy.
+		MinVersion string `json:"min_version" mapstructure:"min_version"`
+		// MaxVersion override the maximal TLS version (ie. "1.3").
+		MaxVersion string `json:"max_version" mapstructure:"max_version"`
+		// Curves override the curves, by preference order (ie. "X25519",
+		// "P256").
+		Curves []string `json:"curves" mapstructure:"curves"`
+		// Ciphers override the TLS 1.0 - 1.2 cipher suites, by their IANA
+		// name (ie. "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"). The TLS 1.3
+		// suites aren't configurable and are rejected.
+		Ciphers []string `json:"ciphers" mapstructure:"ciphers"`
+	}
+
+	// tlsProfile hold the resolved settings of a TLSOptions.
+	tlsProfile struct {
+		curves                 []tls.CurveID
+		ciphers                []uint16
+		minVersion, maxVersion uint16
+	}
+)
+
+var (
+	_intermediateCiphers = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, // HTTP/2-required AES_128_GCM_SHA256 cipher
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	}
+
+	_profiles = map[Profile]tlsProfile{
+		ProfileModern: {
+			minVersion: tls.VersionTLS13, maxVersion: tls.VersionTLS13,
+			curves: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		},
+		ProfileIntermediate: {
+			minVersion: tls.VersionTLS12, maxVersion: tls.VersionTLS13,
+			curves:  []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+			ciphers: _intermediateCiphers,
+		},
+		ProfileLegacy: {
+			minVersion: tls.VersionTLS10, maxVersion: tls.VersionTLS13,
+			curves: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+			ciphers: append(append([]uint16{}, _intermediateCiphers...),
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA),
+		},
+		ProfileFIPS: {
+			minVersion: tls.VersionTLS12, maxVersion: tls.VersionTLS13,
+			curves: []tls.CurveID{tls.CurveP256, tls.CurveP384},
+			ciphers: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			},
+		},
+	}
+
+	_versions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	_curves = map[string]tls.CurveID{
+		"x25519": tls.X25519,
+		"p256":   tls.CurveP256,
+		"p384":   tls.CurveP384,
+		"p521":   tls.CurveP521,
+	}
+)
+
+// Validate check the profile exists and the overrides are known, strong
+// enough and not duplicated.
+func (o *TLSOptions) Validate() error {
+	_, err := o.resolve()
+
+	return err
+}
+
+// resolve return the profile settings with the overrides applied.
+func (o *TLSOptions) resolve() (tlsProfile, error) {
+	if o == nil {
+		return intermediateProfile(), nil
+	}
+
+	name := o.Profile
+	if name == "" {
+		name = ProfileIntermediate
+	}
+
+	p, ok := _profiles[name]
+	if !ok {
+		return p, fmt.Errorf("%w: unknown profile %q (modern, intermediate, legacy or fips)", ErrTLSOptions, name)
+	}
+
+	if name == ProfileIntermediate {
+		p = intermediateProfile()
+	}
+
+	var err error
+
+	if o.MinVersion != "" {
+		if p.minVersion, err = parseVersion(o.MinVersion); err != nil {
+			return p, err
+		} else if p.minVersion < tls.VersionTLS12 && name != ProfileLegacy {
+			return p, fmt.Errorf("%w: min version %q is too weak, use the %s profile",
+				ErrTLSOptions, o.MinVersion, ProfileLegacy)
+		}
+	}
+
+	if o.MaxVersion != "" {
+		if p.maxVersion, err = parseVersion(o.MaxVersion); err != nil {
+			return p, err
+		}
+	}
+
+	if p.minVersion > p.maxVersion {
+		return p, fmt.Errorf("%w: min version %s above max version %s",
+			ErrTLSOptions, tls.VersionName(p.minVersion), tls.VersionName(p.maxVersion))
+	}
+
+	if o.Curves != nil {
+		if p.curves, err = parseCurves(o.Curves); err != nil {
+			return p, err
+		}
+	}
+
+	if o.Ciphers != nil {
+		if p.ciphers, err = parseCiphers(o.Ciphers); err != nil {
+			return p, err
+		}
+	}
+
+	return p, nil
+}
+
+// intermediateProfile return the intermediate profile, its curves and
+// ciphers being DefaultCurve and DefaultCipher.
+func intermediateProfile() tlsProfile {
+	p := _profiles[ProfileIntermediate]
+	p.curves, p.ciphers = slices.Clone(DefaultCurve), slices.Clone(DefaultCipher)
+
+	return p
+}
+
+func parseVersion(v string) (uint16, error) {
+	norm := strings.TrimPrefix(strings.ReplaceAll(strings.ToLower(v), " ", ""), "tls")
+	if version, ok := _versions[norm]; ok {
+		return version, nil
+	}
+
+	return 0, fmt.Errorf("%w: unknown tls version %q (1.0, 1.1, 1.2 or 1.3)", ErrTLSOptions, v)
+}
+
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	out := make([]tls.CurveID, 0, len(names))
+	seen := map[tls.CurveID]bool{}
+
+	for _, name := range names {
+		norm := strings.NewReplacer("curve", "", "-", "", "_", "").Replace(strings.ToLower(name))
+
+		curve, ok := _curves[norm]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown curve %q", ErrTLSOptions, name)
+		} else if seen[curve] {
+			return nil, fmt.Errorf("%w: duplicated curve %q", ErrTLSOptions, name)
+		}
+
+		seen[curve] = true
+		out = append(out, curve)
+	}
+
+	return out, nil
+}
+
+// parseCiphers resolve the suites by name, rejecting the insecure, the
+// non forward secret (RSA key exchange) and the TLS 1.3 ones.
+func parseCiphers(names []string) ([]uint16, error) {
+	known, weak, tls13 := map[string]uint16{}, map[string]bool{}, map[string]bool{}
+
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+		tls13[s.Name] = !slices.ContainsFunc(s.SupportedVersions, func(v uint16) bool { return v < tls.VersionTLS13 })
+	}
+
+	for _, s := range tls.InsecureCipherSuites() {
+		weak[s.Name] = true
+	}
+
+	out := make([]uint16, 0, len(names))
+	seen := map[uint16]bool{}
+
+	for _, name := range names {
+		id, ok := known[name]
+
+		switch {
+		case weak[name] || strings.HasPrefix(name, "TLS_RSA_"):
+			return nil, fmt.Errorf("%w: weak cipher %q", ErrTLSOptions, name)
+		case tls13[name]:
+			return nil, fmt.Errorf("%w: tls 1.3 cipher %q isn't configurable", ErrTLSOptions, name)
+		case !ok:
+			return nil, fmt.Errorf("%w: unknown cipher %q", ErrTLSOptions, name)
+		case seen[id]:
+			return nil, fmt.Errorf("%w: duplicated cipher %q", ErrTLSOptions, name)
+		}
+
+		seen[id] = true
+		out = append(out, id)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,121 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfile(t *testing.T) {
+	requirer := require.New(t)
+
+	server, client := newTestPKI(t).configs(t)
+
+	t.Log("default to intermediate")
+	{
+		cfg, e := GetTLSCfg(server)
+		requirer.Nil(e)
+		requirer.Equal(uint16(tls.VersionTLS12), cfg.MinVersion)
+		requirer.Equal(uint16(tls.VersionTLS13), cfg.MaxVersion)
+		requirer.Equal(DefaultCipher, cfg.CipherSuites)
+
+		for _, id := range cfg.CipherSuites {
+			requirer.Contains(tls.CipherSuiteName(id), "_ECDHE_")
+		}
+	}
+
+	t.Log("profiles and overrides")
+	{
+		for _, tc := range []struct {
+			opts    TLSOptions
+			min     uint16
+			ciphers int
+			curves  []tls.CurveID
+		}{
+			{TLSOptions{Profile: ProfileModern}, tls.VersionTLS13, 0, nil},
+			{TLSOptions{Profile: ProfileLegacy}, tls.VersionTLS10, 10, nil},
+			{TLSOptions{Profile: ProfileLegacy, MinVersion: "1.1"}, tls.VersionTLS11, 10, nil},
+			{TLSOptions{Profile: ProfileFIPS}, tls.VersionTLS12, 4, []tls.CurveID{tls.CurveP256, tls.CurveP384}},
+			{
+				TLSOptions{
+					MinVersion: "TLS 1.3", Curves: []string{"X25519", "P-384"},
+					Ciphers: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+				},
+				tls.VersionTLS13, 1, []tls.CurveID{tls.X25519, tls.CurveP384},
+			},
+		} {
+			in := server
+			in.TLS = &tc.opts
+
+			cfg, e := GetTLSCfg(in)
+			requirer.Nil(e)
+			requirer.Equal(tc.min, cfg.MinVersion, tc.opts)
+			requirer.Len(cfg.CipherSuites, tc.ciphers, tc.opts)
+
+			if tc.curves != nil {
+				requirer.Equal(tc.curves, cfg.CurvePreferences)
+			}
+		}
+	}
+
+	t.Log("invalid options")
+	{
+		for _, opts := range []TLSOptions{
+			{Profile: "paranoid"},
+			{MinVersion: "1.4"},
+			{MinVersion: "1.0"},
+			{Profile: ProfileFIPS, MinVersion: "TLS 1.1"},
+			{MinVersion: "1.3", MaxVersion: "1.2"},
+			{Curves: []string{"P256", "p-256"}},
+			{Curves: []string{"brainpool"}},
+			{Ciphers: []string{"TLS_RSA_WITH_AES_256_GCM_SHA384"}},
+			{Ciphers: []string{"TLS_ECDHE_RSA_WITH_RC4_128_SHA"}},
+			{Ciphers: []string{"TLS_AES_128_GCM_SHA256"}},
+			{Ciphers: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}},
+		} {
+			requirer.True(errors.Is(opts.Validate(), ErrTLSOptions), opts)
+
+			in := client
+			in.TLS = &opts
+			_, e := GetClientTLSCfg(in)
+			requirer.True(errors.Is(e, ErrTLSOptions), opts)
+		}
+	}
+
+	t.Log("modern server reject a TLS 1.2 client")
+	{
+		in := server
+		in.TLS = &TLSOptions{Profile: ProfileModern}
+		srv := startTestServer(t, in)
+
+		requireAccepted(t, srv, client)
+
+		old := client
+		old.TLS = &TLSOptions{MaxVersion: "1.2"}
+		requireRejected(t, srv, old)
+	}
+
+	t.Log("DefaultCipher and DefaultCurve")
+	{
+		prevCipher, prevCurve := DefaultCipher, DefaultCurve
+		t.Cleanup(func() { DefaultCipher, DefaultCurve = prevCipher, prevCurve })
+
+		DefaultCipher = []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+		DefaultCurve = []tls.CurveID{tls.CurveP384}
+
+		cfg, e := GetTLSCfg(server)
+		requirer.Nil(e)
+		requirer.Equal(DefaultCipher, cfg.CipherSuites)
+		requirer.Equal(DefaultCurve, cfg.CurvePreferences)
+
+		in := client
+		in.TLS = &TLSOptions{Ciphers: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}
+
+		c, e := GetClientTLSCfg(in)
+		requirer.Nil(e)
+		requirer.Equal([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, c.CipherSuites)
+		requirer.Equal(DefaultCurve, c.CurvePreferences)
+	}
+}
@@ -0,0 +1,224 @@
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval is the default polling interval of a Reloader.
+const DefaultReloadInterval = 30 * time.Second
+
+type (
+	// Reloader serve the TLS material referenced by a Config and re-load it
+	// when the underlying files change (ie. certificate rotation), without
+	// restarting the listener. New handshakes pick up the fresh material
+	// while the established connections keep working.
+	//
+	// On reload failure the last good material is kept.
+	Reloader struct {
+		onError  func(error)
+		onReload func(Config)
+		log      *slog.Logger
+		state    *serverState
+		src      Config
+		cfg      Config
+		interval time.Duration
+		mu       sync.RWMutex
+		// loadMu serialize the loads, so an older load never swap in its
+		// material after a newer one
+		loadMu sync.Mutex
+		http2  bool
+	}
+
+	// ReloaderOption customize a Reloader.
+	ReloaderOption func(*Reloader)
+)
+
+// WithReloadInterval set the polling interval of Reloader.Watch.
+func WithReloadInterval(d time.Duration) ReloaderOption {
+	return func(r *Reloader) { r.interval = d }
+}
+
+// WithReloadErrorHandler set the callback fired on reload failure.
+// Default to logging the error via the Reloader logger.
+func WithReloadErrorHandler(fn func(error)) ReloaderOption {
+	return func(r *Reloader) { r.onError = fn }
+}
+
+// WithReloadHandler set the callback fired after each successful reload.
+func WithReloadHandler(fn func(Config)) ReloaderOption {
+	return func(r *Reloader) { r.onReload = fn }
+}
+
+// WithReloadLogger set the logger used by the Reloader (default to slog.Default).
+func WithReloadLogger(l *slog.Logger) ReloaderOption {
+	return func(r *Reloader) { r.log = l }
+}
+
+// WithReloadHTTP2 add 'h2' to the NextProto list of the served config.
+func WithReloadHTTP2() ReloaderOption {
+	return func(r *Reloader) { r.http2 = true }
+}
+
+// NewReloader load the material referenced by cfg and return a Reloader
+// serving it. The initial load must succeed. If cfg.Hash is set, each load
+// must match it: the material is pinned and a changed content is rejected.
+func NewReloader(cfg Config, opts ...ReloaderOption) (*Reloader, error) {
+	r := &Reloader{interval: DefaultReloadInterval, log: slog.Default()}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.onError == nil {
+		r.onError = func(e error) {
+			attrs := append(r.Config().AsAttrs(), CertAttrs(r.Certificates())...)
+			r.log.Error("reloading tls material", append(attrs, slog.Any("error", e))...)
+		}
+	}
+
+	r.src = cfg
+
+	if _, e := r.load(cfg); e != nil {
+		return nil, e
+	}
+
+	return r, nil
+}
+
+// Config return the config of the currently served material. Its Hash
+// field is set to the content hash of the material.
+func (r *Reloader) Config() Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cfg
+}
+
+// Reload re-read the material. It return true if new material is now
+// served, false if the content didn't change. On error the last good
+// material is kept and the error handler is fired. Concurrent reloads (ie.
+// Watch ticks, SIGHUP and manual calls) are serialized.
+func (r *Reloader) Reload() (bool, error) {
+	ok, e := r.load(r.src)
+	if e != nil {
+		r.onError(e)
+
+		return false, e
+	}
+
+	if ok && r.onReload != nil {
+		r.onReload(r.Config())
+	}
+
+	return ok, nil
+}
+
+// Watch poll the material every interval until the context is done.
+func (r *Reloader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = r.Reload()
+		}
+	}
+}
+
+// Certificates return the validity of the currently served certificates.
+func (r *Reloader) Certificates() []CertInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.state.certs
+}
+
+// GetCertificate implement the tls.Config GetCertificate callback.
+func (r *Reloader) GetCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if hi == nil {
+		return r.state.certificate(), nil
+	}
+
+	return r.state.forServerName(hi.ServerName).certificate(), nil
+}
+
+// GetConfigForClient implement the tls.Config GetConfigForClient callback.
+func (r *Reloader) GetConfigForClient(hi *tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.RLock()
+	state := r.state
+	r.mu.RUnlock()
+
+	return state.getConfigForClient(hi)
+}
+
+// level return the level enforced for a connection by the served material.
+func (r *Reloader) level(serverName string, local, remote net.Addr) Level {
+	r.mu.RLock()
+	levels := levelResolver{cfg: r.cfg, routes: r.state.routes}
+	r.mu.RUnlock()
+
+	return levels.level(serverName, local, remote)
+}
+
+// TLSConfig return a tls config serving the reloaded material, to be used
+// with LoadListner.
+func (r *Reloader) TLSConfig() *tls.Config {
+	r.mu.RLock()
+	profile := r.state.profile
+	r.mu.RUnlock()
+
+	/* #nosec */
+	out := getBaseTLSCfg(profile, nil, r.http2)
+	out.GetCertificate = r.GetCertificate
+	out.GetConfigForClient = r.GetConfigForClient
+
+	return out
+}
+
+// load read and parse the material, swapping it if its content changed.
+func (r *Reloader) load(cfg Config) (bool, error) {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+
+	raw, err := readMaterial(cfg)
+	if err != nil {
+		return false, err
+	}
+
+	next := cfg
+	if next.Hash, err = cfg.checkHash(raw); err != nil {
+		return false, err
+	}
+
+	// the content hash is the change detection key
+	if prev := r.Config(); prev.Hash != "" && next.SameAs(prev) {
+		return false, next.Expiry.check(r.Certificates())
+	}
+
+	state, err := newServerState(next, raw, r.http2)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	prev := r.cfg
+	r.cfg, r.state = next, state
+	r.mu.Unlock()
+
+	if prev.Hash != "" {
+		r.log.Info("tls material reloaded", slog.Any("changed", next.Diff(prev)))
+	}
+
+	return true, nil
+}
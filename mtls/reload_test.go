@@ -0,0 +1,183 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+func issueTestCert(t *testing.T, dir, cn string) (cert, key string) {
+	t.Helper()
+
+	ca, e := generate.NewCA(generate.WithCommonName(cn + "-ca"))
+	require.Nil(t, e)
+
+	c, e := ca.Issue(generate.WithCommonName(cn))
+	require.Nil(t, e)
+	require.Nil(t, c.WriteFiles(dir, cn))
+
+	return filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+}
+
+func leafCN(t *testing.T, c *tls.Certificate) string {
+	t.Helper()
+
+	require.NotNil(t, c)
+	require.NotNil(t, c.Leaf)
+
+	return c.Leaf.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		d          = t.TempDir()
+		cert, key  = issueTestCert(t, d, "first")
+		reloaded   Config
+		reloadErrs []error
+	)
+
+	r, e := NewReloader(Config{Cert: cert, Key: key},
+		WithReloadHandler(func(c Config) { reloaded = c }),
+		WithReloadErrorHandler(func(e error) { reloadErrs = append(reloadErrs, e) }))
+	requirer.Nil(e)
+	requirer.NotEmpty(r.Config().Hash)
+
+	c, e := r.GetCertificate(nil)
+	requirer.Nil(e)
+	requirer.Equal("first", leafCN(t, c))
+
+	t.Log("no-op reload")
+	{
+		ok, e := r.Reload()
+		requirer.Nil(e)
+		requirer.False(ok)
+		requirer.Empty(reloaded.Hash)
+	}
+
+	t.Log("rotated material")
+	{
+		hash := r.Config().Hash
+		ncert, nkey := issueTestCert(t, t.TempDir(), "second")
+
+		for src, dst := range map[string]string{ncert: cert, nkey: key} {
+			b, e := os.ReadFile(src)
+			requirer.Nil(e)
+			requirer.Nil(os.WriteFile(dst, b, 0o600))
+		}
+
+		ok, e := r.Reload()
+		requirer.Nil(e)
+		requirer.True(ok)
+		requirer.NotEqual(hash, r.Config().Hash)
+		requirer.Equal(r.Config(), reloaded)
+
+		c, e := r.GetCertificate(nil)
+		requirer.Nil(e)
+		requirer.Equal("second", leafCN(t, c))
+
+		out, e := r.GetConfigForClient(&tls.ClientHelloInfo{})
+		requirer.Nil(e)
+		requirer.Equal("second", leafCN(t, &out.Certificates[0]))
+		requirer.Equal(tls.RequestClientCert, out.ClientAuth)
+	}
+
+	t.Log("broken material keep the last good one")
+	{
+		requirer.Nil(os.WriteFile(cert, []byte("garbage"), 0o600))
+
+		ok, e := r.Reload()
+		requirer.NotNil(e)
+		requirer.False(ok)
+		requirer.Len(reloadErrs, 1)
+
+		c, e := r.GetCertificate(nil)
+		requirer.Nil(e)
+		requirer.Equal("second", leafCN(t, c))
+	}
+
+	t.Log("missing files")
+	{
+		requirer.Nil(os.Remove(key))
+
+		_, e := r.Reload()
+		requirer.True(errors.Is(e, os.ErrNotExist))
+	}
+}
+
+func TestReloaderSerialized(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		d       = t.TempDir()
+		entered = make(chan struct{})
+		release = make(chan struct{})
+		mu      sync.Mutex
+		block   bool
+		src     = map[string]string{}
+	)
+
+	setMaterial := func(cn string) {
+		cert, key := issueTestCert(t, d, cn)
+
+		mu.Lock()
+		src["cert"], src["key"] = cert, key
+		mu.Unlock()
+	}
+
+	// the key is the last source read: a blocked load hold consistent
+	// material
+	loader := LoaderFunc(func(ref string) ([]byte, error) {
+		mu.Lock()
+		path, wait := src[ref], block && ref == "key"
+		block = block && !wait
+		mu.Unlock()
+
+		if wait {
+			close(entered)
+			<-release
+		}
+
+		return os.ReadFile(path)
+	})
+
+	setMaterial("initial")
+
+	r, e := NewReloader(Config{Cert: "cert", Key: "key", Loader: loader},
+		WithReloadErrorHandler(func(error) {}))
+	requirer.Nil(e)
+
+	setMaterial("older")
+
+	mu.Lock()
+	block = true
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	go func() { defer wg.Done(); _, _ = r.Reload() }()
+
+	<-entered
+	setMaterial("newer")
+
+	go func() { defer wg.Done(); _, _ = r.Reload() }()
+
+	// let the newer load run if the loads weren't serialized
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	c, e := r.GetCertificate(nil)
+	requirer.Nil(e)
+	requirer.Equal("newer", leafCN(t, c), "the newer load is served last")
+}
@@ -0,0 +1,455 @@
+package mtls
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// DefaultCRLRefresh is the default CRL reload interval.
+	DefaultCRLRefresh = time.Hour
+	// DefaultOCSPTimeout is the default timeout of the OCSP requests.
+	DefaultOCSPTimeout = 5 * time.Second
+	// DefaultOCSPCacheTTL is the cache duration of the OCSP responses which
+	// don't specify any next update.
+	DefaultOCSPCacheTTL = time.Hour
+
+	_maxOCSPResponse = 1 << 20
+)
+
+var (
+	// ErrRevocationUnavailable error is returned if the revocation status of
+	// a certificate can't be established (and SoftFail isn't enabled).
+	ErrRevocationUnavailable = errors.New("revocation status unavailable")
+	// ErrNoOCSPServer error is returned if no OCSP responder is known for
+	// a certificate.
+	ErrNoOCSPServer = errors.New("no OCSP responder")
+	// ErrNoIssuer error is returned if the issuer of the server certificate
+	// can't be found, which is required for OCSP stapling.
+	ErrNoIssuer = errors.New("issuer certificate not found")
+	// ErrCRLSignature error is returned if the CRL isn't signed by the issuer
+	// of the checked certificate.
+	ErrCRLSignature = errors.New("invalid CRL signature")
+	// ErrCRLExpired error is returned if the CRL is past its NextUpdate.
+	ErrCRLExpired = errors.New("expired CRL")
+	// ErrOCSPStatus error is returned for non successful OCSP responses.
+	ErrOCSPStatus = errors.New("unexpected OCSP responder status")
+)
+
+type (
+	// Revocation configure the revocation checks run against the verified
+	// client certificates, and the OCSP stapling of the server certificate.
+	Revocation struct {
+		// CRL is the source of the CRL, PEM or DER encoded (see SourceFile).
+		CRL string `json:"crl"          mapstructure:"crl"`
+		// CRLRefresh is the CRL reload interval (default to DefaultCRLRefresh).
+		CRLRefresh time.Duration `json:"crl_refresh"  mapstructure:"crl_refresh"`
+		// OCSP enable the OCSP check of the client certificates.
+		OCSP bool `json:"ocsp"         mapstructure:"ocsp"`
+		// OCSPServer override the responder listed by the certificates.
+		OCSPServer string `json:"ocsp_server"  mapstructure:"ocsp_server"`
+		// OCSPTimeout bound the OCSP requests (default to DefaultOCSPTimeout).
+		OCSPTimeout time.Duration `json:"ocsp_timeout" mapstructure:"ocsp_timeout"`
+		// SoftFail accept the certificates which revocation status can't be
+		// established (responder down, unknown status, CRL unavailable or expired).
+		SoftFail bool `json:"soft_fail"    mapstructure:"soft_fail"`
+		// Staple staple the OCSP response of the server certificate.
+		Staple bool `json:"staple"       mapstructure:"staple"`
+		// Logger log the CRL and staple refresh failures (default to
+		// slog.Default).
+		Logger *slog.Logger `json:"-" mapstructure:"-"`
+	}
+
+	// RevokedError is returned if a certificate has been revoked.
+	RevokedError struct {
+		RevokedAt time.Time
+		Serial    *big.Int
+		// Source is either "crl" or "ocsp".
+		Source string
+	}
+
+	// revocationChecker implement the CRL and OCSP checks and the stapling.
+	revocationChecker struct {
+		crl        *x509.RevocationList
+		crlLoaded  time.Time
+		cache      map[string]ocspEntry
+		load       func(string) ([]byte, error)
+		client     *http.Client
+		staple     atomic.Pointer[ocspStaple]
+		issuer     *x509.Certificate
+		cfg        Revocation
+		mu         sync.Mutex
+		refreshing atomic.Bool
+		// crlRefreshing is set while the CRL is reloaded in the background
+		crlRefreshing atomic.Bool
+	}
+
+	ocspEntry struct {
+		until time.Time
+		err   error
+	}
+
+	ocspStaple struct {
+		until time.Time
+		raw   []byte
+	}
+)
+
+func (e RevokedError) Error() string {
+	return fmt.Sprintf("certificate %s revoked at %s (%s)",
+		e.Serial.Text(16), e.RevokedAt.Format(time.RFC3339), e.Source)
+}
+
+// newRevocationChecker return nil if the config doesn't hold any
+// revocation settings. The cert is the served one, stapled if requested.
+func newRevocationChecker(cfg Config, raw rawMaterial, cert tls.Certificate) (*revocationChecker, error) {
+	if cfg.Revocation == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	c := &revocationChecker{
+		cfg:   *cfg.Revocation,
+		load:  cfg.load,
+		cache: make(map[string]ocspEntry),
+	}
+
+	if c.cfg.CRLRefresh <= 0 {
+		c.cfg.CRLRefresh = DefaultCRLRefresh
+	}
+
+	if c.cfg.OCSPTimeout <= 0 {
+		c.cfg.OCSPTimeout = DefaultOCSPTimeout
+	}
+
+	if c.cfg.Logger == nil {
+		c.cfg.Logger = slog.Default()
+	}
+
+	c.client = &http.Client{Timeout: c.cfg.OCSPTimeout}
+
+	if c.cfg.CRL != "" {
+		if err := c.loadCRL(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.cfg.Staple {
+		if err := c.initStaple(cert, raw.ca); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// withoutLogger return a copy of the settings without their Logger.
+func (r *Revocation) withoutLogger() *Revocation {
+	if r == nil {
+		return nil
+	}
+
+	out := *r
+	out.Logger = nil
+
+	return &out
+}
+
+// check run the CRL and OCSP checks against the leaf of the verified chain.
+func (c *revocationChecker) check(chain []*x509.Certificate) error {
+	if c == nil || len(chain) < 2 {
+		return nil
+	}
+
+	leaf, issuer := chain[0], chain[1]
+
+	if err := c.checkCRL(leaf, issuer); err != nil {
+		return err
+	}
+
+	if !c.cfg.OCSP {
+		return nil
+	}
+
+	return c.checkOCSP(leaf, issuer)
+}
+
+// unavailable apply the soft/hard fail policy.
+func (c *revocationChecker) unavailable(err error) error {
+	if c.cfg.SoftFail {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", ErrRevocationUnavailable, err)
+}
+
+func (c *revocationChecker) loadCRL() error {
+	b, err := c.load(c.cfg.CRL)
+	if err != nil {
+		return fmt.Errorf("cannot load crl %q: %w", describeSource(c.cfg.CRL), err)
+	}
+
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return fmt.Errorf("cannot parse crl %q: %w", describeSource(c.cfg.CRL), err)
+	}
+
+	c.mu.Lock()
+	c.crl, c.crlLoaded = crl, time.Now()
+	c.mu.Unlock()
+
+	return nil
+}
+
+// currentCRL return the CRL. A stale CRL is reloaded in the background,
+// the last good one being kept on reload failure.
+func (c *revocationChecker) currentCRL() *x509.RevocationList {
+	c.mu.Lock()
+	crl, stale := c.crl, time.Since(c.crlLoaded) > c.cfg.CRLRefresh
+	c.mu.Unlock()
+
+	if stale && c.crlRefreshing.CompareAndSwap(false, true) {
+		go func() {
+			defer c.crlRefreshing.Store(false)
+
+			if err := c.loadCRL(); err != nil {
+				c.cfg.Logger.Warn("reloading crl, using the last good one", slog.Any("error", err))
+
+				c.mu.Lock()
+				c.crlLoaded = time.Now()
+				c.mu.Unlock()
+			}
+		}()
+	}
+
+	return crl
+}
+
+func (c *revocationChecker) checkCRL(leaf, issuer *x509.Certificate) error {
+	if c.cfg.CRL == "" {
+		return nil
+	}
+
+	crl := c.currentCRL()
+
+	// the CRL only cover the certificates of its issuer
+	if !bytes.Equal(crl.RawIssuer, leaf.RawIssuer) {
+		return nil
+	}
+
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("%w: %w", ErrCRLSignature, err)
+	}
+
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+			return RevokedError{RevokedAt: entry.RevocationTime, Serial: leaf.SerialNumber, Source: "crl"}
+		}
+	}
+
+	// an expired CRL may miss the latest revocations
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		return c.unavailable(fmt.Errorf("%w: next update was %s", ErrCRLExpired, crl.NextUpdate))
+	}
+
+	return nil
+}
+
+func (c *revocationChecker) checkOCSP(leaf, issuer *x509.Certificate) error {
+	key := string(leaf.RawIssuer) + leaf.SerialNumber.String()
+
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(entry.until) {
+		return entry.err
+	}
+
+	resp, _, err := c.fetchOCSP(leaf, issuer)
+	if err != nil {
+		return c.unavailable(err)
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		entry.err = nil
+	case ocsp.Revoked:
+		entry.err = RevokedError{RevokedAt: resp.RevokedAt, Serial: leaf.SerialNumber, Source: "ocsp"}
+	default:
+		return c.unavailable(fmt.Errorf("%w: unknown certificate status", ErrOCSPStatus))
+	}
+
+	entry.until = ocspUntil(resp)
+
+	c.mu.Lock()
+	c.cache[key] = entry
+	c.mu.Unlock()
+
+	return entry.err
+}
+
+// fetchOCSP query the OCSP responder for the status of the certificate.
+func (c *revocationChecker) fetchOCSP(cert, issuer *x509.Certificate) (*ocsp.Response, []byte, error) {
+	server := c.cfg.OCSPServer
+	if server == "" {
+		if len(cert.OCSPServer) == 0 {
+			return nil, nil, ErrNoOCSPServer
+		}
+
+		server = cert.OCSPServer[0]
+	}
+
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating the ocsp request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.OCSPTimeout)
+	defer cancel()
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating the ocsp request: %w", err)
+	}
+
+	hreq.Header.Set("Content-Type", "application/ocsp-request")
+
+	hresp, err := c.client.Do(hreq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("requesting the ocsp responder: %w", err)
+	}
+	defer hresp.Body.Close()
+
+	if hresp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%w: %s", ErrOCSPStatus, hresp.Status)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(hresp.Body, _maxOCSPResponse))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading the ocsp response: %w", err)
+	}
+
+	resp, err := ocsp.ParseResponseForCert(raw, cert, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing the ocsp response: %w", err)
+	}
+
+	return resp, raw, nil
+}
+
+// initStaple fetch the first OCSP staple of the server certificate.
+func (c *revocationChecker) initStaple(cert tls.Certificate, caPEM []byte) error {
+	var err error
+	if c.issuer, err = findIssuer(cert, caPEM); err != nil {
+		return err
+	}
+
+	if err := c.refreshStaple(cert.Certificate[0]); err != nil && !c.cfg.SoftFail {
+		return fmt.Errorf("stapling the server certificate: %w", err)
+	}
+
+	return nil
+}
+
+func (c *revocationChecker) refreshStaple(der []byte) error {
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("parsing the certificate to staple: %w", err)
+	}
+
+	resp, raw, err := c.fetchOCSP(leaf, c.issuer)
+	if err != nil {
+		return err
+	} else if resp.Status != ocsp.Good {
+		return fmt.Errorf("%w: server certificate status %d", ErrOCSPStatus, resp.Status)
+	}
+
+	c.staple.Store(&ocspStaple{raw: raw, until: ocspUntil(resp)})
+
+	return nil
+}
+
+// stapled return a copy of the certificate with the current OCSP staple.
+// An expired staple is refreshed in the background.
+func (c *revocationChecker) stapled(cert *tls.Certificate) *tls.Certificate {
+	if !c.cfg.Staple {
+		return cert
+	}
+
+	st := c.staple.Load()
+	if (st == nil || time.Now().After(st.until)) && c.refreshing.CompareAndSwap(false, true) {
+		go func() {
+			defer c.refreshing.Store(false)
+
+			if err := c.refreshStaple(cert.Certificate[0]); err != nil {
+				c.cfg.Logger.Warn("refreshing the ocsp staple", slog.Any("error", err))
+			}
+		}()
+	}
+
+	if st == nil || time.Now().After(st.until) {
+		return cert
+	}
+
+	out := *cert
+	out.OCSPStaple = st.raw
+
+	return &out
+}
+
+func ocspUntil(resp *ocsp.Response) time.Time {
+	if resp.NextUpdate.IsZero() {
+		return time.Now().Add(DefaultOCSPCacheTTL)
+	}
+
+	return resp.NextUpdate
+}
+
+// findIssuer look for the issuer of the certificate leaf in its chain, then
+// in the PEM encoded CA bundle.
+func findIssuer(cert tls.Certificate, caPEM []byte) (*x509.Certificate, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing the certificate: %w", err)
+	}
+
+	candidates := make([]*x509.Certificate, 0, len(cert.Certificate))
+
+	for _, der := range cert.Certificate[1:] {
+		if c, err := x509.ParseCertificate(der); err == nil {
+			candidates = append(candidates, c)
+		}
+	}
+
+	for block, rest := pem.Decode(caPEM); block != nil; block, rest = pem.Decode(rest) {
+		if c, err := x509.ParseCertificate(block.Bytes); err == nil {
+			candidates = append(candidates, c)
+		}
+	}
+
+	for _, c := range candidates {
+		if leaf.CheckSignatureFrom(c) == nil {
+			return c, nil
+		}
+	}
+
+	return nil, ErrNoIssuer
+}
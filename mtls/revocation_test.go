@@ -0,0 +1,260 @@
+package mtls
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// startOCSPResponder start a local OCSP responder signed by the CA,
+// answering the status registered for each serial (default to good).
+func startOCSPResponder(t *testing.T, ca *generate.Certificate, status map[string]int,
+) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	hits := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+
+		b, _ := io.ReadAll(r.Body)
+
+		req, e := ocsp.ParseRequest(b)
+		if e != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		now := time.Now()
+		tmpl := ocsp.Response{
+			Status:       status[req.SerialNumber.String()],
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   now,
+			NextUpdate:   now.Add(time.Hour),
+			RevokedAt:    now.Add(-time.Hour),
+		}
+
+		resp, e := ocsp.CreateResponse(ca.Cert, ca.Cert, tmpl, ca.Key)
+		if e != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, hits
+}
+
+func requireRejected(t *testing.T, srv *httptest.Server, client Config) {
+	t.Helper()
+
+	c, e := NewHTTPClient(client)
+	require.Nil(t, e)
+
+	_, e = get(t, c, srv.URL)
+	require.NotNil(t, e, "the client should have been rejected")
+}
+
+func requireAccepted(t *testing.T, srv *httptest.Server, client Config) {
+	t.Helper()
+
+	c, e := NewHTTPClient(client)
+	require.Nil(t, e)
+
+	body, e := get(t, c, srv.URL)
+	require.Nil(t, e)
+	require.True(t, strings.HasSuffix(body, " client"))
+}
+
+func TestRevocation(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki            = newTestPKI(t)
+		server, client = pki.configs(t)
+		clientSerial   = pki.client.Cert.SerialNumber
+	)
+
+	t.Log("CRL")
+	{
+		crl, e := pki.ca.CRL()
+		requirer.Nil(e)
+
+		cfg := server
+		cfg.Revocation = &Revocation{CRL: string(crl)}
+		requireAccepted(t, startTestServer(t, cfg), client)
+
+		crl, e = pki.ca.CRL(clientSerial)
+		requirer.Nil(e)
+
+		cfg.Revocation = &Revocation{CRL: string(crl)}
+		requireRejected(t, startTestServer(t, cfg), client)
+
+		cfg.Revocation = &Revocation{CRL: filepath.Join(t.TempDir(), "missing.crl")}
+		_, e = GetTLSCfg(cfg)
+		requirer.NotNil(e)
+	}
+
+	t.Log("CRL refreshed in the background")
+	{
+		var (
+			crlFile = filepath.Join(t.TempDir(), "ca.crl")
+			logs    = &syncBuffer{}
+		)
+
+		crl, e := pki.ca.CRL()
+		requirer.Nil(e)
+		requirer.Nil(os.WriteFile(crlFile, crl, 0o600))
+
+		cfg := server
+		cfg.Revocation = &Revocation{
+			CRL: crlFile, CRLRefresh: 10 * time.Millisecond,
+			Logger: slog.New(slog.NewTextHandler(logs, nil)),
+		}
+		srv := startTestServer(t, cfg)
+		requireAccepted(t, srv, client)
+
+		crl, e = pki.ca.CRL(clientSerial)
+		requirer.Nil(e)
+		requirer.Nil(os.WriteFile(crlFile, crl, 0o600))
+
+		c, e := NewHTTPClient(client)
+		requirer.Nil(e)
+
+		rejected := func() bool {
+			c.CloseIdleConnections()
+			_, e := get(t, c, srv.URL)
+
+			return e != nil
+		}
+		requirer.Eventually(rejected, time.Second, 20*time.Millisecond)
+
+		// the reload failure keep the last good CRL
+		requirer.Nil(os.Remove(crlFile))
+		requirer.Eventually(func() bool {
+			return rejected() && strings.Contains(logs.String(), "reloading crl, using the last good one")
+		}, time.Second, 20*time.Millisecond)
+	}
+
+	t.Log("expired CRL")
+	{
+		now := time.Now()
+
+		der, e := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:     big.NewInt(now.UnixNano()),
+			ThisUpdate: now.Add(-48 * time.Hour),
+			NextUpdate: now.Add(-24 * time.Hour),
+		}, pki.ca.Cert, pki.ca.Key)
+		requirer.Nil(e)
+
+		crl := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+
+		cfg := server
+		cfg.Revocation = &Revocation{CRL: string(crl)}
+		requireRejected(t, startTestServer(t, cfg), client)
+
+		cfg.Revocation = &Revocation{CRL: string(crl), SoftFail: true}
+		requireAccepted(t, startTestServer(t, cfg), client)
+	}
+
+	t.Log("OCSP")
+	{
+		status := map[string]int{}
+		responder, hits := startOCSPResponder(t, pki.ca, status)
+
+		cfg := server
+		cfg.Revocation = &Revocation{OCSP: true, OCSPServer: responder.URL}
+		srv := startTestServer(t, cfg)
+
+		requireAccepted(t, srv, client)
+		requireAccepted(t, srv, client)
+		requirer.Equal(int32(1), hits.Load(), "the good status should be cached")
+
+		status[clientSerial.String()] = ocsp.Revoked
+		requireRejected(t, startTestServer(t, cfg), client)
+
+		status[clientSerial.String()] = ocsp.Unknown
+		requireRejected(t, startTestServer(t, cfg), client)
+	}
+
+	t.Log("OCSP soft / hard fail")
+	{
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+
+		cfg := server
+		cfg.Revocation = &Revocation{OCSP: true, OCSPServer: down.URL}
+		requireRejected(t, startTestServer(t, cfg), client)
+
+		cfg.Revocation = &Revocation{OCSP: true, OCSPServer: down.URL, SoftFail: true}
+		requireAccepted(t, startTestServer(t, cfg), client)
+	}
+
+	t.Log("OCSP stapling")
+	{
+		responder, _ := startOCSPResponder(t, pki.ca, map[string]int{})
+
+		cfg := server
+		cfg.Revocation = &Revocation{Staple: true, OCSPServer: responder.URL}
+		srv := startTestServer(t, cfg)
+
+		tlsCfg, e := GetClientTLSCfg(client)
+		requirer.Nil(e)
+
+		conn, e := tls.Dial("tcp", srv.Listener.Addr().String(), tlsCfg)
+		requirer.Nil(e)
+
+		defer conn.Close()
+
+		staple := conn.ConnectionState().OCSPResponse
+		requirer.NotEmpty(staple)
+
+		resp, e := ocsp.ParseResponse(staple, pki.ca.Cert)
+		requirer.Nil(e)
+		requirer.Equal(ocsp.Good, resp.Status)
+	}
+
+	t.Log("OCSP stapling with an encrypted key")
+	{
+		responder, _ := startOCSPResponder(t, pki.ca, map[string]int{})
+
+		d := t.TempDir()
+		requirer.Nil(pki.server.WriteEncryptedFiles(d, "server", []byte("secret")))
+		t.Setenv("TEST_MTLS_PASSPHRASE", "secret")
+
+		cfg := server
+		cfg.Cert, cfg.Key = filepath.Join(d, "server.crt"), filepath.Join(d, "server.key")
+		cfg.KeyPassphrase = "env:TEST_MTLS_PASSPHRASE"
+		cfg.Revocation = &Revocation{Staple: true, OCSPServer: responder.URL}
+		srv := startTestServer(t, cfg)
+
+		tlsCfg, e := GetClientTLSCfg(client)
+		requirer.Nil(e)
+
+		conn, e := tls.Dial("tcp", srv.Listener.Addr().String(), tlsCfg)
+		requirer.Nil(e)
+
+		defer conn.Close()
+
+		requirer.NotEmpty(conn.ConnectionState().OCSPResponse)
+	}
+}
@@ -0,0 +1,291 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrRoute error is returned for an invalid Route.
+var ErrRoute = errors.New("invalid route")
+
+// Route override the client authentication of the handshakes it match, so
+// a single listener can serve mixed trust traffic (ie. `never` for the
+// health checks port, `hard` elsewhere).
+//
+// A route match if each of its non empty criteria match; the first
+// matching route of Config.Routes is applied.
+type Route struct {
+	// ServerNames match the requested SNI, exact names or wildcards
+	// (ie. `*.example.org`). The SNI is chosen by the client, which may
+	// then request another Host over the connection: Server reject those
+	// requests (see RequireHostMatch), a custom http.Server should wrap its
+	// handler with RequireHostMatch.
+	ServerNames []string `json:"server_names,omitempty" mapstructure:"server_names"`
+	// Ports match the local port of the connection.
+	Ports []int `json:"ports,omitempty" mapstructure:"ports"`
+	// CIDRs match the remote address of the connection (addresses or CIDRs).
+	CIDRs []string `json:"cidrs,omitempty" mapstructure:"cidrs"`
+	// Level override the authentication level.
+	Level *Level `json:"level,omitempty" mapstructure:"level"`
+	// Ca override the source of the CA certificate. As for Config.Ca, the
+	// self-signed certificates of the bundle are the roots and the other
+	// ones intermediates, replacing the Config.Intermediates.
+	Ca string `json:"ca,omitempty" mapstructure:"ca"`
+	// Policy override the SAN policy.
+	Policy *Policy `json:"policy,omitempty" mapstructure:"policy"`
+}
+
+// routeState hold the parsed criteria and overrides of a Route.
+type routeState struct {
+	names    []string
+	ports    []int
+	prefixes []netip.Prefix
+	level    *Level
+	ca       *x509.CertPool
+	// intermediates are the ones of the route CA bundle, replacing the
+	// default ones along the ca
+	intermediates []*x509.Certificate
+	policy        *Policy
+}
+
+// Validate check the route has at least one valid criterion.
+func (r Route) Validate() error {
+	if len(r.ServerNames) == 0 && len(r.Ports) == 0 && len(r.CIDRs) == 0 {
+		return fmt.Errorf("%w: no server name, port nor cidr to match", ErrRoute)
+	}
+
+	for _, port := range r.Ports {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("%w: invalid port %d", ErrRoute, port)
+		}
+	}
+
+	for _, cidr := range r.CIDRs {
+		if _, e := parsePrefix(cidr); e != nil {
+			return fmt.Errorf("%w: invalid cidr %q: %w", ErrRoute, cidr, e)
+		}
+	}
+
+	return r.Policy.Validate()
+}
+
+// selectByServerName return true if the level or the CA of a handshake
+// depend on its server name.
+func (cfg Config) selectByServerName() bool {
+	return len(cfg.SNI) > 0 || slices.ContainsFunc(cfg.Routes, func(r Route) bool { return len(r.ServerNames) > 0 })
+}
+
+// RequireHostMatch reject with a 421 Misdirected Request the TLS requests
+// whose Host isn't the server name of the connection, so a client can't
+// request a host over a connection authenticated for another server name.
+// Without SNI, only an IP Host is accepted.
+func RequireHostMatch(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && !hostMatch(r.Host, r.TLS.ServerName) {
+			http.Error(w, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hostMatch return true if the Host header match the server name.
+func hostMatch(host, serverName string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if serverName == "" {
+		_, err := netip.ParseAddr(host)
+
+		return err == nil
+	}
+
+	return strings.EqualFold(host, strings.TrimSuffix(serverName, "."))
+}
+
+// readRoutesCA read the CA source of each route.
+func (raw *rawMaterial) readRoutesCA(cfg Config) error {
+	raw.routes = make([][]byte, len(cfg.Routes))
+
+	for i, r := range cfg.Routes {
+		if r.Ca == "" {
+			continue
+		}
+
+		b, err := cfg.load(r.Ca)
+		if err != nil {
+			return fmt.Errorf("cannot load route ca cert %q in pool: %w", describeSource(r.Ca), err)
+		}
+
+		raw.routes[i] = b
+	}
+
+	return nil
+}
+
+func newRouteStates(cfg Config, raw rawMaterial) ([]routeState, error) {
+	out := make([]routeState, 0, len(cfg.Routes))
+
+	for i, r := range cfg.Routes {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+
+		state := routeState{names: r.ServerNames, ports: r.Ports, level: r.Level, policy: r.Policy}
+
+		for _, cidr := range r.CIDRs {
+			prefix, _ := parsePrefix(cidr)
+			state.prefixes = append(state.prefixes, prefix)
+		}
+
+		if b := raw.routes[i]; b != nil {
+			var err error
+			if state.ca, state.intermediates, err = (rawMaterial{cas: [][]byte{b}}).caPools(); err != nil {
+				return nil, fmt.Errorf("route %d: %w", i, err)
+			}
+		}
+
+		out = append(out, state)
+	}
+
+	return out, nil
+}
+
+// route return the state with the overrides of the first route matching
+// the handshake applied.
+func (s *serverState) route(served *serverState, hi *tls.ClientHelloInfo) *serverState {
+	var local, remote net.Addr
+	if hi.Conn != nil {
+		local, remote = hi.Conn.LocalAddr(), hi.Conn.RemoteAddr()
+	}
+
+	for _, r := range s.routes {
+		if !r.match(hi.ServerName, local, remote) {
+			continue
+		}
+
+		out := *served
+
+		if r.level != nil {
+			out.level = serverLevel(*r.level)
+		}
+
+		if r.ca != nil {
+			out.ca, out.intermediates = r.ca, r.intermediates
+		}
+
+		if r.policy != nil {
+			out.policy = r.policy
+		}
+
+		return &out
+	}
+
+	return served
+}
+
+// match return true if the connection match the route criteria.
+func (r routeState) match(serverName string, local, remote net.Addr) bool {
+	if len(r.names) > 0 {
+		name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+		if !slices.ContainsFunc(r.names, func(pattern string) bool {
+			pattern = strings.ToLower(pattern)
+
+			return matchServerName(pattern, name, false) || matchServerName(pattern, name, true)
+		}) {
+			return false
+		}
+	}
+
+	if len(r.ports) > 0 {
+		if local == nil || !slices.Contains(r.ports, addrPort(local)) {
+			return false
+		}
+	}
+
+	if len(r.prefixes) > 0 {
+		if remote == nil {
+			return false
+		}
+
+		addr, ok := addrIP(remote)
+		if !ok || !slices.ContainsFunc(r.prefixes, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func addrPort(addr net.Addr) int {
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0
+	}
+
+	p, _ := strconv.Atoi(port)
+
+	return p
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return ip.Unmap(), true
+}
+
+// levelResolver compute the level enforced for a connection, the SNI
+// entries and the routes considered, without loading the material.
+type levelResolver struct {
+	cfg    Config
+	routes []routeState
+}
+
+func newLevelResolver(cfg Config) (*levelResolver, error) {
+	routes, err := newRouteStates(cfg, rawMaterial{routes: make([][]byte, len(cfg.Routes))})
+	if err != nil {
+		return nil, err
+	}
+
+	return &levelResolver{cfg: cfg, routes: routes}, nil
+}
+
+func (lr *levelResolver) level(serverName string, local, remote net.Addr) Level {
+	lvl, sni := lr.cfg.Level, lr.cfg.SNI
+	if i := sniIndex(len(sni), func(i int) []string { return sni[i].ServerNames }, serverName); i >= 0 &&
+		sni[i].Level != nil {
+		lvl = *sni[i].Level
+	}
+
+	for _, route := range lr.routes {
+		if route.match(serverName, local, remote) {
+			if route.level != nil {
+				lvl = *route.level
+			}
+
+			break
+		}
+	}
+
+	return lvl
+}
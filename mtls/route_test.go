@@ -0,0 +1,282 @@
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutes(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki            = newTestPKI(t)
+		server, client = pki.configs(t)
+		never          = NoClientCert
+		anonymous      = client
+	)
+
+	anonymous.Cert, anonymous.Key = "", ""
+
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	requirer.Nil(e)
+
+	addr := ln.Addr().String()
+	port := addrPort(ln.Addr())
+
+	t.Log("by local port")
+	{
+		ln2, e := net.Listen("tcp", "127.0.0.1:0")
+		requirer.Nil(e)
+
+		cfg := server
+		cfg.Routes = []Route{{Ports: []int{port}, Level: &never}}
+		serveTLS(t, ln, cfg, nil)
+		serveTLS(t, ln2, cfg, nil)
+
+		_, e = dialCN(t, addr, anonymous, "localhost")
+		requirer.Nil(e, "the health check port doesn't require a client certificate")
+
+		_, e = dialCN(t, ln2.Addr().String(), anonymous, "localhost")
+		requirer.NotNil(e)
+
+		_, e = dialCN(t, ln2.Addr().String(), client, "localhost")
+		requirer.Nil(e)
+	}
+
+	t.Log("by server name and remote address")
+	{
+		for _, tc := range []struct {
+			route      Route
+			serverName string
+			accepted   bool
+		}{
+			{Route{ServerNames: []string{"*.health.local"}, Level: &never}, "lb.health.local", true},
+			{Route{ServerNames: []string{"*.health.local"}, Level: &never}, "localhost", false},
+			{Route{CIDRs: []string{"127.0.0.0/8"}, Level: &never}, "localhost", true},
+			{Route{CIDRs: []string{"10.0.0.0/8"}, Level: &never}, "localhost", false},
+			{Route{CIDRs: []string{"127.0.0.1"}, ServerNames: []string{"other"}, Level: &never}, "localhost", false},
+		} {
+			ln, e := net.Listen("tcp", "127.0.0.1:0")
+			requirer.Nil(e)
+
+			cfg := server
+			cfg.Routes = []Route{tc.route}
+			serveTLS(t, ln, cfg, nil)
+
+			c := anonymous
+			c.Insecure = true
+
+			_, e = dialCN(t, ln.Addr().String(), c, tc.serverName)
+			requirer.Equal(tc.accepted, e == nil, "%+v %s: %v", tc.route, tc.serverName, e)
+		}
+	}
+
+	t.Log("CA and policy override")
+	{
+		other, e := generate.NewCA(generate.WithCommonName("other-ca"))
+		requirer.Nil(e)
+
+		for _, route := range []Route{
+			{CIDRs: []string{"127.0.0.1"}, Ca: string(other.CertPEM())},
+			{CIDRs: []string{"127.0.0.1"}, Level: ptrLevel(RequireAndVerifyClientCertAndSAN), Policy: &Policy{CN: []string{"admin"}}},
+		} {
+			ln, e := net.Listen("tcp", "127.0.0.1:0")
+			requirer.Nil(e)
+
+			cfg := server
+			cfg.Routes = []Route{route}
+			serveTLS(t, ln, cfg, nil)
+
+			_, e = dialCN(t, ln.Addr().String(), client, "localhost")
+			requirer.NotNil(e)
+		}
+	}
+
+	t.Log("CA override with an intermediates bundle")
+	{
+		other, e := generate.NewCA(generate.WithCommonName("other-ca"))
+		requirer.Nil(e)
+
+		otherInter, e := other.NewIntermediate(generate.WithCommonName("other-intermediate"))
+		requirer.Nil(e)
+
+		inter, e := pki.ca.NewIntermediate(generate.WithCommonName("intermediate"))
+		requirer.Nil(e)
+
+		leaf, e := otherInter.Issue(generate.WithCommonName("routed"))
+		requirer.Nil(e)
+
+		defaultLeaf, e := inter.Issue(generate.WithCommonName("default"))
+		requirer.Nil(e)
+
+		cfg := server
+		cfg.Intermediates = string((&generate.Certificate{Cert: inter.Cert}).CertPEM())
+		cfg.Routes = []Route{{
+			ServerNames: []string{"routed.local"},
+			Ca:          string(other.CertPEM()) + string((&generate.Certificate{Cert: otherInter.Cert}).CertPEM()),
+		}}
+
+		tlsCfg, e := GetTLSCfg(cfg)
+		requirer.Nil(e)
+
+		conn, _ := net.Pipe()
+		rc := &recordingConn{Conn: conn}
+
+		routed, e := tlsCfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "routed.local", Conn: rc})
+		requirer.Nil(e)
+
+		want := x509.NewCertPool()
+		want.AddCert(other.Cert)
+		want.AddCert(otherInter.Cert)
+		requirer.True(want.Equal(routed.ClientCAs), "the default intermediates aren't advertised")
+
+		requirer.Nil(routed.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.Cert}}))
+		requirer.Len(rc.chains[0], 3, "the route intermediate isn't a root")
+		requirer.True(rc.chains[0][2].Equal(other.Cert))
+
+		e = routed.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{defaultLeaf.Cert}})
+		requirer.True(errors.Is(e, ErrChain))
+	}
+
+	t.Log("cross-route session resumption")
+	{
+		other, e := generate.NewCA(generate.WithCommonName("other-ca"))
+		requirer.Nil(e)
+
+		for _, strict := range []Route{
+			{ServerNames: []string{"strict.local"}, Level: ptrLevel(RequireAndVerifyClientCertAndSAN), Policy: &Policy{CN: []string{"admin"}}},
+			{ServerNames: []string{"strict.local"}, Ca: string(other.CertPEM())},
+		} {
+			for _, version := range []string{"1.2", "1.3"} {
+				ln, e := net.Listen("tcp", "127.0.0.1:0")
+				requirer.Nil(e)
+
+				cfg := server
+				cfg.Routes = []Route{strict}
+				serveTLS(t, ln, cfg, nil)
+
+				in := client
+				in.TLS = &TLSOptions{MaxVersion: version}
+
+				tlsCfg, e := GetClientTLSCfg(in)
+				requirer.Nil(e)
+
+				// a malicious client offer the ticket of lax.local to
+				// strict.local
+				tlsCfg.InsecureSkipVerify, tlsCfg.ClientSessionCache = true, &replayCache{}
+
+				dial := func(serverName string) (bool, error) {
+					c := tlsCfg.Clone()
+					c.ServerName = serverName
+
+					cs, e := dialTLS(t, "tcp", ln.Addr().String(), c)
+
+					return cs.DidResume, e
+				}
+
+				_, e = dial("strict.local")
+				requirer.NotNil(e, "%+v tls %s", strict, version)
+
+				_, e = dial("lax.local")
+				requirer.Nil(e)
+
+				resumed, e := dial("lax.local")
+				requirer.Nil(e)
+				requirer.True(resumed, "the session ticket is offered")
+
+				_, e = dial("strict.local")
+				requirer.NotNil(e, "%+v tls %s: resumed into strict.local", strict, version)
+			}
+		}
+	}
+
+	t.Log("SNI and Host mismatch")
+	{
+		ln, e := net.Listen("tcp", "127.0.0.1:0")
+		requirer.Nil(e)
+
+		cfg := server
+		cfg.Routes = []Route{{ServerNames: []string{"lax.local"}, Level: &never}}
+
+		srv, e := NewServer("", cfg, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			WithServerListener(ln), WithShutdownSignals(), WithReloadSignal(nil), WithServerReloadInterval(0))
+		requirer.Nil(e)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		go func() { _ = srv.ListenAndServe(ctx) }()
+		<-srv.Ready()
+
+		for _, tc := range []struct {
+			client           Config
+			serverName, host string
+			status           int
+		}{
+			{anonymous, "lax.local", "lax.local", http.StatusOK},
+			{anonymous, "lax.local", "LAX.local:443", http.StatusOK},
+			{anonymous, "lax.local", "strict.local", http.StatusMisdirectedRequest},
+			{client, "", "127.0.0.1", http.StatusOK},
+			{client, "", "strict.local", http.StatusMisdirectedRequest},
+		} {
+			c := tc.client
+			c.Insecure = true
+
+			tr, e := NewHTTPTransport(c)
+			requirer.Nil(e)
+
+			tr.TLSClientConfig.ServerName = tc.serverName
+
+			req, e := http.NewRequest(http.MethodGet, "https://"+ln.Addr().String(), nil) //nolint: noctx
+			requirer.Nil(e)
+
+			req.Host = tc.host
+
+			resp, e := (&http.Client{Transport: tr}).Do(req)
+			requirer.Nil(e, "%s %s", tc.serverName, tc.host)
+			requirer.Nil(resp.Body.Close())
+			requirer.Equal(tc.status, resp.StatusCode, "%s %s", tc.serverName, tc.host)
+		}
+	}
+
+	t.Log("invalid routes")
+	{
+		for _, route := range []Route{
+			{Level: &never},
+			{Ports: []int{70000}},
+			{CIDRs: []string{"10.0.0.0/33"}},
+			{Ports: []int{443}, Policy: &Policy{IP: []string{"nope"}}},
+		} {
+			cfg := server
+			cfg.Routes = []Route{route}
+
+			_, e := GetTLSCfg(cfg)
+			requirer.NotNil(e, "%+v", route)
+		}
+
+		requirer.True(errors.Is(Route{}.Validate(), ErrRoute))
+	}
+}
+
+func ptrLevel(l Level) *Level { return &l }
+
+// replayCache offer the last session ticket whatever the server name.
+type replayCache struct {
+	session *tls.ClientSessionState
+}
+
+func (c *replayCache) Get(string) (*tls.ClientSessionState, bool) { return c.session, c.session != nil }
+
+func (c *replayCache) Put(_ string, cs *tls.ClientSessionState) {
+	if cs != nil {
+		c.session = cs
+	}
+}
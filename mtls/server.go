@@ -0,0 +1,317 @@
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultDrainTimeout is the default delay given to the in-flight
+	// requests to complete on shutdown.
+	DefaultDrainTimeout = 30 * time.Second
+	// DefaultReadHeaderTimeout is the default http.Server ReadHeaderTimeout.
+	DefaultReadHeaderTimeout = 10 * time.Second
+)
+
+// ErrServerStarted error is returned if a Server is started more than once.
+var ErrServerStarted = errors.New("server already started")
+
+type (
+	// Server serve an http.Handler over mTLS. It own the listener, the
+	// reload of the TLS material (polling and SIGHUP) and the graceful
+	// shutdown (on context cancellation or SIGINT / SIGTERM).
+	Server struct {
+		srv            *http.Server
+		reloader       *Reloader
+		ln             net.Listener
+		log            *slog.Logger
+		ready          chan struct{}
+		stopWatch      context.CancelFunc
+		reloadSignal   os.Signal
+		addr           string
+		cfg            Config
+		signals        []os.Signal
+		drainTimeout   time.Duration
+		reloadInterval time.Duration
+		watchDone      sync.WaitGroup
+		mu             sync.Mutex
+		http2          bool
+		started        bool
+	}
+
+	// ServerOption customize a Server.
+	ServerOption func(*Server)
+)
+
+// WithServerHTTP2 enable HTTP/2.
+func WithServerHTTP2() ServerOption {
+	return func(s *Server) { s.http2 = true }
+}
+
+// WithServerLogger set the logger used by the Server, its Reloader and for
+// the handshake errors (default to slog.Default).
+func WithServerLogger(l *slog.Logger) ServerOption {
+	return func(s *Server) { s.log = l }
+}
+
+// WithDrainTimeout set the delay given to the in-flight requests to
+// complete on shutdown.
+func WithDrainTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.drainTimeout = d }
+}
+
+// WithShutdownSignals set the signals triggering the graceful shutdown
+// (default to SIGINT and SIGTERM). No signal disable the handling.
+func WithShutdownSignals(sig ...os.Signal) ServerOption {
+	return func(s *Server) { s.signals = sig }
+}
+
+// WithReloadSignal set the signal triggering a reload of the TLS material
+// (default to SIGHUP). A nil signal disable the handling.
+func WithReloadSignal(sig os.Signal) ServerOption {
+	return func(s *Server) { s.reloadSignal = sig }
+}
+
+// WithServerReloadInterval set the polling interval of the TLS material
+// (default to DefaultReloadInterval). Zero disable the polling.
+func WithServerReloadInterval(d time.Duration) ServerOption {
+	return func(s *Server) { s.reloadInterval = d }
+}
+
+// WithServerListener serve on an existing listener (ie. inherited via
+// systemd socket activation) instead of listening on the address.
+func WithServerListener(ln net.Listener) ServerOption {
+	return func(s *Server) { s.ln = ln }
+}
+
+// NewServer load the TLS material of cfg and return a Server serving the
+// handler on addr (see LoadListner, Config.Network and Config.Observer).
+func NewServer(addr string, cfg Config, handler http.Handler, opts ...ServerOption) (*Server, error) {
+	s := &Server{
+		addr:           addr,
+		cfg:            cfg,
+		log:            slog.Default(),
+		ready:          make(chan struct{}),
+		signals:        []os.Signal{os.Interrupt, syscall.SIGTERM},
+		reloadSignal:   syscall.SIGHUP,
+		drainTimeout:   DefaultDrainTimeout,
+		reloadInterval: DefaultReloadInterval,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	ropts := []ReloaderOption{WithReloadLogger(s.log)}
+	if s.http2 {
+		ropts = append(ropts, WithReloadHTTP2())
+	}
+
+	r, err := NewReloader(cfg, ropts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.reloader = r
+	s.srv = &http.Server{
+		Handler:           s.requireHostMatch(handler),
+		TLSConfig:         r.TLSConfig(),
+		ErrorLog:          slog.NewLogLogger(s.log.Handler(), slog.LevelWarn),
+		ReadHeaderTimeout: DefaultReadHeaderTimeout,
+		ConnContext:       ConnContext,
+	}
+
+	if !s.http2 {
+		s.srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	return s, nil
+}
+
+// requireHostMatch apply RequireHostMatch while the served config select
+// the level or the CA by server name.
+func (s *Server) requireHostMatch(next http.Handler) http.Handler {
+	checked := RequireHostMatch(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.reloader.Config().selectByServerName() {
+			checked.ServeHTTP(w, r)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Reloader return the Reloader serving the TLS material.
+func (s *Server) Reloader() *Reloader { return s.reloader }
+
+// HTTPServer return the underlying http.Server, ie. to set its timeouts
+// before serving.
+func (s *Server) HTTPServer() *http.Server { return s.srv }
+
+// Ready return a channel closed once the server accept connections.
+func (s *Server) Ready() <-chan struct{} { return s.ready }
+
+// Addr return the listened address, nil before the server is ready.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ln == nil {
+		return nil
+	}
+
+	return s.ln.Addr()
+}
+
+// ListenAndServe serve until the context is done or a shutdown signal is
+// received, then gracefully shutdown the server within the drain timeout.
+// It return nil once the server is shut down. A Server is only served
+// once, ErrServerStarted is returned by the next calls.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	s.mu.Lock()
+	started := s.started
+	s.started = true
+	s.mu.Unlock()
+
+	if started {
+		return ErrServerStarted
+	}
+
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	if len(s.signals) > 0 {
+		var stop context.CancelFunc
+
+		ctx, stop = signal.NotifyContext(ctx, s.signals...)
+		defer stop()
+	}
+
+	s.startWatch()
+
+	errs := make(chan error, 1)
+
+	go func() { errs <- s.srv.Serve(ln) }()
+
+	close(s.ready)
+	attrs := append(s.reloader.Config().AsAttrs(), CertAttrs(s.reloader.Certificates())...)
+	s.log.Info("mtls server ready", append(attrs, slog.String("addr", ln.Addr().String()))...)
+
+	select {
+	case err = <-errs:
+		s.stopWatching()
+	case <-ctx.Done():
+		drain, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+		defer cancel()
+
+		err = s.Shutdown(drain)
+		<-errs
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown stop the reload of the TLS material and gracefully shutdown the
+// server (see http.Server.Shutdown).
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopWatching()
+	s.log.Info("mtls server shutting down")
+
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ln := s.ln
+	if ln != nil {
+		ln = WrapListener(ln, s.srv.TLSConfig)
+	} else {
+		var err error
+		if ln, err = LoadListner(s.addr, s.srv.TLSConfig, WithNetwork(s.cfg.Network)); err != nil {
+			return nil, err
+		}
+	}
+
+	// the levels are resolved from the served material, so they follow the
+	// reloads
+	s.ln = observeListener(ln, s.cfg.Observer, s.reloader.level, s.cfg.HandshakeTimeout)
+
+	return s.ln, nil
+}
+
+// startWatch reload the TLS material on each tick and reload signal until
+// stopWatching is called.
+func (s *Server) startWatch() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.stopWatch = cancel
+	s.mu.Unlock()
+
+	var (
+		tick <-chan time.Time
+		hup  = make(chan os.Signal, 1)
+	)
+
+	if s.reloadInterval > 0 {
+		ticker := time.NewTicker(s.reloadInterval)
+		tick = ticker.C
+
+		context.AfterFunc(ctx, ticker.Stop)
+	}
+
+	if s.reloadSignal != nil {
+		signal.Notify(hup, s.reloadSignal)
+		context.AfterFunc(ctx, func() { signal.Stop(hup) })
+	}
+
+	s.watchDone.Add(1)
+
+	go func() {
+		defer s.watchDone.Done()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			case <-hup:
+				s.log.Info("mtls server reloading the tls material")
+			}
+
+			_, _ = s.reloader.Reload()
+		}
+	}()
+}
+
+// stopWatching stop the reload loop and wait for an in-flight reload.
+func (s *Server) stopWatching() {
+	s.mu.Lock()
+	stop := s.stopWatch
+	s.mu.Unlock()
+
+	if stop != nil {
+		stop()
+		s.watchDone.Wait()
+	}
+}
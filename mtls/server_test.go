@@ -0,0 +1,121 @@
+package mtls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestServer(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki           = newTestPKI(t)
+		_, client     = pki.configs(t)
+		cert, key, ca = pki.writeFiles(t)
+		logs          = &syncBuffer{}
+		served        = make(chan error, 1)
+		handler       = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(r.Proto)) })
+		cfg           = Config{Cert: cert, Key: key, Ca: ca, Level: RequireAndVerifyClientCert}
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, e := NewServer("127.0.0.1:0", cfg, handler,
+		WithServerHTTP2(), WithServerReloadInterval(10*time.Millisecond),
+		WithServerLogger(slog.New(slog.NewTextHandler(logs, nil))),
+		WithShutdownSignals(), WithDrainTimeout(time.Second))
+	requirer.Nil(e)
+	requirer.Nil(srv.Addr())
+
+	go func() { served <- srv.ListenAndServe(ctx) }()
+
+	select {
+	case <-srv.Ready():
+	case e := <-served:
+		requirer.FailNow("the server didn't start", e)
+	}
+
+	addr := srv.Addr().String()
+
+	requirer.True(errors.Is(srv.ListenAndServe(ctx), ErrServerStarted))
+
+	t.Log("http2 round trip")
+	{
+		c, e := NewHTTPClient(client, true)
+		requirer.Nil(e)
+
+		body, e := get(t, c, "https://"+addr)
+		requirer.Nil(e)
+		requirer.Equal("HTTP/2.0", body)
+	}
+
+	t.Log("handshake errors are logged")
+	{
+		anonymous := client
+		anonymous.Cert, anonymous.Key = "", ""
+
+		c, e := NewHTTPClient(anonymous)
+		requirer.Nil(e)
+
+		_, e = get(t, c, "https://"+addr)
+		requirer.NotNil(e)
+		requirer.Eventually(func() bool {
+			return strings.Contains(logs.String(), "TLS handshake error")
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	t.Log("certificate rotation")
+	{
+		rotated, e := pki.ca.Issue(generate.WithCommonName("rotated"), generate.WithDNSNames("localhost"))
+		requirer.Nil(e)
+		requirer.Nil(rotated.WriteFiles(filepath.Dir(cert), "test"))
+
+		requirer.Eventually(func() bool {
+			cn, e := dialCN(t, addr, client, "localhost")
+
+			return e == nil && cn == "rotated"
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	t.Log("graceful shutdown")
+	{
+		cancel()
+		requirer.Nil(<-served)
+
+		_, e := dialCN(t, addr, client, "localhost")
+		requirer.NotNil(e)
+		requirer.Contains(logs.String(), "mtls server shutting down")
+	}
+}
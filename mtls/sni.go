@@ -0,0 +1,116 @@
+package mtls
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrSNIEntry error is returned for an SNIEntry without server name.
+var ErrSNIEntry = errors.New("sni entry without server name")
+
+// SNIEntry serve a dedicated certificate to the clients requesting one of
+// its server names. The Ca and Level default to the Config ones.
+type SNIEntry struct {
+	// ServerNames are the matched SNI, either exact names or wildcards
+	// (ie. `*.example.org`, matching a single label).
+	ServerNames []string `json:"server_names" mapstructure:"server_names"`
+	// Cert is the source of the TLS certificate (see SourceFile).
+	Cert string `json:"cert" mapstructure:"cert"`
+	// Key is the source of the TLS key (see SourceFile).
+	Key string `json:"key" mapstructure:"key"`
+	// Ca is the source of the CA certificate, replacing the Config Ca, CAs
+	// and SystemRoots.
+	Ca string `json:"ca,omitempty" mapstructure:"ca"`
+	// Level is the authentication level, default to the Config one.
+	Level *Level `json:"level,omitempty" mapstructure:"level"`
+}
+
+// sniConfig return the config of the i-th SNI entry, inheriting the unset
+// settings from cfg.
+func (cfg Config) sniConfig(i int) Config {
+	entry := cfg.SNI[i]
+
+	out := cfg
+	out.SNI, out.Routes, out.Hash, out.PKCS12 = nil, nil, "", ""
+	out.Cert, out.Key = entry.Cert, entry.Key
+
+	if entry.Ca != "" {
+		out.Ca, out.CAs, out.SystemRoots = entry.Ca, nil, false
+	}
+
+	if entry.Level != nil {
+		out.Level = *entry.Level
+	}
+
+	return out
+}
+
+// sniRoute hold the state served to the clients requesting one of names.
+type sniRoute struct {
+	names []string
+	state *serverState
+}
+
+// newSNIRoutes load the material of each SNI entry.
+func newSNIRoutes(cfg Config, raw rawMaterial, http2 ...bool) ([]sniRoute, error) {
+	out := make([]sniRoute, 0, len(cfg.SNI))
+
+	for i, entry := range cfg.SNI {
+		if len(entry.ServerNames) == 0 {
+			return nil, fmt.Errorf("%w: entry %d", ErrSNIEntry, i)
+		}
+
+		state, err := newServerState(cfg.sniConfig(i), raw.sni[i], http2...)
+		if err != nil {
+			return nil, fmt.Errorf("sni %s: %w", strings.Join(entry.ServerNames, ","), err)
+		}
+
+		out = append(out, sniRoute{names: entry.ServerNames, state: state})
+	}
+
+	return out, nil
+}
+
+// forServerName return the state serving the server name, default to s.
+func (s *serverState) forServerName(name string) *serverState {
+	i := sniIndex(len(s.sni), func(i int) []string { return s.sni[i].names }, name)
+	if i < 0 {
+		return s
+	}
+
+	return s.sni[i].state
+}
+
+// sniIndex return the index of the SNI entry exactly matching the server
+// name, else of the first one matching it via a wildcard, else -1.
+func sniIndex(n int, names func(int) []string, name string) int {
+	if name == "" {
+		return -1
+	}
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	for _, wildcard := range []bool{false, true} {
+		for i := 0; i < n; i++ {
+			for _, pattern := range names(i) {
+				if matchServerName(strings.ToLower(pattern), name, wildcard) {
+					return i
+				}
+			}
+		}
+	}
+
+	return -1
+}
+
+func matchServerName(pattern, name string, wildcard bool) bool {
+	domain, isWildcard := strings.CutPrefix(pattern, "*.")
+	if !wildcard || !isWildcard {
+		return !wildcard && pattern == name
+	}
+
+	_, rest, found := strings.Cut(name, ".")
+
+	return found && rest == domain
+}
@@ -0,0 +1,80 @@
+package mtls
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNI(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki            = newTestPKI(t)
+		server, client = pki.configs(t)
+		never          = NoClientCert
+	)
+
+	issue := func(cn string, names ...string) SNIEntry {
+		c, e := pki.ca.Issue(generate.WithCommonName(cn), generate.WithDNSNames(names...))
+		requirer.Nil(e)
+
+		cert, key := pemOf(t, c)
+
+		return SNIEntry{ServerNames: names, Cert: cert, Key: key}
+	}
+
+	api, wildcard := issue("api", "api.example.org"), issue("wildcard", "*.example.org")
+	wildcard.Level = &never
+	server.SNI = []SNIEntry{wildcard, api}
+
+	srv := startTestServer(t, server)
+	addr := srv.Listener.Addr().String()
+
+	t.Log("served certificate by server name")
+	{
+		for name, cn := range map[string]string{
+			"api.example.org": "api",
+			"API.example.org": "api",
+			"www.example.org": "wildcard",
+			"localhost":       "server",
+		} {
+			got, e := dialCN(t, addr, client, name)
+			requirer.Nil(e, name)
+			requirer.Equal(cn, got, name)
+		}
+	}
+
+	t.Log("per entry level")
+	{
+		anonymous := client
+		anonymous.Cert, anonymous.Key = "", ""
+
+		got, e := dialCN(t, addr, anonymous, "www.example.org")
+		requirer.Nil(e)
+		requirer.Equal("wildcard", got)
+
+		_, e = dialCN(t, addr, anonymous, "api.example.org")
+		requirer.NotNil(e)
+
+		_, e = dialCN(t, addr, anonymous, "localhost")
+		requirer.NotNil(e)
+	}
+
+	t.Log("json format and validation")
+	{
+		var cfg Config
+
+		requirer.Nil(json.Unmarshal([]byte(`{"sni": [{"server_names": ["*.example.org"], "level": "never"}]}`), &cfg))
+		requirer.Equal([]string{"*.example.org"}, cfg.SNI[0].ServerNames)
+		requirer.Equal(NoClientCert, *cfg.SNI[0].Level)
+
+		in := server
+		in.SNI = []SNIEntry{{Cert: api.Cert, Key: api.Key}}
+		_, e := GetTLSCfg(in)
+		requirer.True(errors.Is(e, ErrSNIEntry))
+	}
+}
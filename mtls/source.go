@@ -0,0 +1,184 @@
+package mtls
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// The Cert, Key and Ca fields of a Config reference their content by one
+// of the following form:
+//
+//	-----BEGIN ...    inline PEM content
+//	base64:<data>     base64 encoded PEM content
+//	env:<NAME>        environment variable holding the PEM or base64 content
+//	file:<path>       path to a file
+//	<path>            path to a file
+//
+// A path to a directory load its PEM files (*.pem, *.crt, *.cer and the
+// hashed names of a c_rehash directory, ie. /etc/ssl/certs), concatenated.
+const (
+	SourceBase64 = "base64:"
+	SourceEnv    = "env:"
+	SourceFile   = "file:"
+
+	_pemPrefix = "-----BEGIN"
+)
+
+var (
+	// ErrEmptySource error is returned if an env source is unset or empty.
+	ErrEmptySource = errors.New("empty source")
+
+	_hashedName = regexp.MustCompile(`^[0-9a-f]{8}\.[0-9]+$`)
+
+	_ Loader = LoaderFunc(nil)
+	_ Loader = (*sourceLoader)(nil)
+)
+
+type (
+	// Loader fetch the content referenced by the Cert, Key and Ca fields of
+	// a Config. It allow to plug any secret store (ie. vault).
+	Loader interface {
+		Load(ref string) ([]byte, error)
+	}
+
+	// LoaderFunc is an adapter to use a plain function as a Loader.
+	LoaderFunc func(ref string) ([]byte, error)
+
+	// sourceLoader resolve the inline, base64 and env sources, and read the
+	// files and directories via the read and readDir functions.
+	sourceLoader struct {
+		read    func(string) ([]byte, error)
+		readDir func(string) ([]fs.DirEntry, error)
+	}
+)
+
+// Load implement Loader.
+func (fn LoaderFunc) Load(ref string) ([]byte, error) { return fn(ref) }
+
+// FSLoader return a Loader reading the file sources from fsys (ie. an
+// embed.FS). The inline, base64 and env sources are still supported.
+func FSLoader(fsys fs.FS) Loader {
+	return &sourceLoader{
+		read: func(name string) ([]byte, error) {
+			return fs.ReadFile(fsys, strings.TrimPrefix(name, "/"))
+		},
+		readDir: func(name string) ([]fs.DirEntry, error) {
+			return fs.ReadDir(fsys, strings.TrimPrefix(name, "/"))
+		},
+	}
+}
+
+// DefaultLoader is the Loader used if the Config doesn't specify any.
+// It read the file sources from the OS filesystem.
+func DefaultLoader() Loader {
+	return &sourceLoader{read: os.ReadFile, readDir: os.ReadDir}
+}
+
+// Load implement Loader.
+func (l *sourceLoader) Load(ref string) ([]byte, error) {
+	switch {
+	case IsInlinePEM(ref):
+		return []byte(strings.TrimSpace(ref)), nil
+
+	case strings.HasPrefix(ref, SourceBase64):
+		return decodeBase64(strings.TrimPrefix(ref, SourceBase64))
+
+	case strings.HasPrefix(ref, SourceEnv):
+		name := strings.TrimPrefix(ref, SourceEnv)
+
+		v := strings.TrimSpace(os.Getenv(name))
+		if v == "" {
+			return nil, fmt.Errorf("env %q: %w", name, ErrEmptySource)
+		} else if IsInlinePEM(v) {
+			return []byte(v), nil
+		}
+
+		return decodeBase64(v)
+
+	default:
+		name := strings.TrimPrefix(ref, SourceFile)
+
+		b, err := l.read(name)
+		if err != nil {
+			if entries, e := l.readDir(name); e == nil {
+				return l.loadDir(name, entries)
+			}
+		}
+
+		return b, err
+	}
+}
+
+// loadDir return the concatenated content of the PEM files of the
+// directory.
+func (l *sourceLoader) loadDir(dir string, entries []fs.DirEntry) ([]byte, error) {
+	var out []byte
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isPEMFile(entry.Name()) {
+			continue
+		}
+
+		b, err := l.read(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(append(out, bytes.TrimSpace(b)...), '\n')
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("directory %q: %w", dir, ErrEmptySource)
+	}
+
+	return out, nil
+}
+
+// isPEMFile return true for the PEM file names and the hashed names
+// (<8 hex digits>.<n>) of a c_rehash directory.
+func isPEMFile(name string) bool {
+	switch path.Ext(name) {
+	case ".pem", ".crt", ".cer":
+		return true
+	}
+
+	return _hashedName.MatchString(name)
+}
+
+// describeSource return a printable description of the source, never
+// exposing inline content.
+func describeSource(ref string) string {
+	switch {
+	case ref == "":
+		return ""
+	case IsInlinePEM(ref):
+		return "inline PEM"
+	case strings.HasPrefix(ref, SourceBase64):
+		return "inline base64"
+	default:
+		return ref
+	}
+}
+
+// IsInlinePEM return true if the source is inline PEM content.
+func IsInlinePEM(ref string) bool {
+	return strings.HasPrefix(strings.TrimSpace(ref), _pemPrefix)
+}
+
+func decodeBase64(data string) ([]byte, error) {
+	data = strings.TrimSpace(data)
+
+	out, e := base64.StdEncoding.DecodeString(data)
+	if e != nil {
+		return nil, fmt.Errorf("decoding base64 source: %w", e)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,120 @@
+package mtls
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSources(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki             = newTestPKI(t)
+		cert, key, ca   = pki.writeFiles(t)
+		certPEM, keyPEM = pemOf(t, pki.server)
+		caPEM           = string(pki.ca.CertPEM())
+	)
+
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	t.Log("describe never leak inline content")
+	{
+		requirer.Equal("inline PEM", describeSource(keyPEM))
+		requirer.Equal("inline base64", describeSource(SourceBase64+b64(keyPEM)))
+		requirer.Equal("env:TLS_KEY", describeSource("env:TLS_KEY"))
+		requirer.Equal(key, describeSource(key))
+		requirer.NotContains(fmt.Sprint(Config{Cert: certPEM, Key: keyPEM}.AsAttrs()...), "PRIVATE KEY")
+	}
+
+	t.Log("inline PEM")
+	{
+		requirer.True(IsInlinePEM("\n" + keyPEM))
+		requirer.False(IsInlinePEM(SourceBase64 + b64(keyPEM)))
+		requirer.False(IsInlinePEM(key))
+	}
+
+	t.Log("every source load the same content")
+	{
+		t.Setenv("TEST_TLS_PEM", keyPEM)
+		t.Setenv("TEST_TLS_B64", b64(keyPEM))
+
+		for _, ref := range []string{
+			key,
+			SourceFile + key,
+			"\n" + keyPEM + "\n",
+			SourceBase64 + b64(keyPEM),
+			SourceEnv + "TEST_TLS_PEM",
+			SourceEnv + "TEST_TLS_B64",
+		} {
+			b, e := DefaultLoader().Load(ref)
+			requirer.Nil(e, describeSource(ref))
+			requirer.Equal(strings.TrimSpace(keyPEM), strings.TrimSpace(string(b)), describeSource(ref))
+		}
+	}
+
+	t.Log("invalid sources")
+	{
+		_, e := DefaultLoader().Load(SourceEnv + "TEST_TLS_UNSET")
+		requirer.True(errors.Is(e, ErrEmptySource))
+
+		_, e = DefaultLoader().Load(SourceBase64 + "!!")
+		requirer.NotNil(e)
+	}
+
+	t.Log("inline config")
+	{
+		cfg, e := GetTLSCfg(Config{
+			Cert: certPEM, Key: SourceBase64 + b64(keyPEM), Ca: caPEM,
+			Level: RequireAndVerifyClientCert,
+		})
+		requirer.Nil(e)
+		requirer.Len(cfg.Certificates, 1)
+		requirer.NotNil(cfg.ClientCAs)
+	}
+
+	t.Log("fs.FS config")
+	{
+		fsys := fstest.MapFS{
+			"certs/test.crt": {Data: []byte(certPEM)},
+			"certs/test.key": {Data: []byte(keyPEM)},
+			"certs/ca.crt":   {Data: []byte(caPEM)},
+		}
+
+		cfg, e := GetTLSCfg(Config{
+			Cert: "certs/test.crt", Key: "/certs/test.key", Ca: "file:certs/ca.crt",
+			Loader: FSLoader(fsys),
+		})
+		requirer.Nil(e)
+		requirer.Len(cfg.Certificates, 1)
+		requirer.NotNil(cfg.ClientCAs)
+
+		_, e = GetTLSCfg(Config{Cert: cert, Key: key, Ca: ca, Loader: FSLoader(fsys)})
+		requirer.NotNil(e, "the host files aren't in the fs.FS")
+	}
+
+	t.Log("custom loader")
+	{
+		var refs []string
+
+		cfg, e := GetTLSCfg(Config{
+			Cert: "vault://cert", Key: "vault://key",
+			Loader: LoaderFunc(func(ref string) ([]byte, error) {
+				refs = append(refs, ref)
+				if ref == "vault://cert" {
+					return []byte(certPEM), nil
+				}
+
+				return []byte(keyPEM), nil
+			}),
+		})
+		requirer.Nil(e)
+		requirer.Len(cfg.Certificates, 1)
+		requirer.Equal([]string{"vault://cert", "vault://key"}, refs)
+	}
+}
@@ -5,32 +5,27 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
-	"os"
+	"slices"
 	"strings"
-	"time"
 )
 
 const H2TLSProto = "h2"
 
 var (
-	// DefaultCurve represent the supported TLS curves.
-	DefaultCurve = []tls.CurveID{tls.CurveP256, tls.X25519}
-
-	// DefaultCipher represent the accepted ciphers.
-	DefaultCipher = []uint16{
-		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,   // HTTP/2-required AES_128_GCM_SHA256 cipher
-		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,   // ECDHE-RSA-AES256-GCM-SHA384
-		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,   // ECDH-RSA-AES256-GCM-SHA384
-		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, // ECDHE-ECDSA-AES256-GCM-SHA384
-		tls.TLS_AES_128_GCM_SHA256,                  // 1.3 tls cipher
-		tls.TLS_AES_256_GCM_SHA384,                  // 1.3 tls cipher
-		tls.TLS_CHACHA20_POLY1305_SHA256,            // 1.3 tls cipher
-		/* unaproved ? */
-		tls.TLS_RSA_WITH_AES_256_GCM_SHA384, // ECDH-RSA-AES256-SHA384
-	}
+	// DefaultCurve represent the curves of the intermediate (default)
+	// profile, unless overridden by TLSOptions.Curves.
+	DefaultCurve = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+
+	// DefaultCipher represent the ciphers of the intermediate (default)
+	// profile, unless overridden by TLSOptions.Ciphers.
+	DefaultCipher = append([]uint16{}, _intermediateCiphers...)
 
 	// ErrParseUserCA error is returned in case of invalid ca cert path.
 	ErrParseUserCA = errors.New("failed to parse root certificate")
+
+	// ErrNoPeerCertificate error is returned if the peer didn't present any
+	// verified certificate.
+	ErrNoPeerCertificate = errors.New("no verified peer certificate")
 )
 
 // GetTLSCfg return a tls config ready for mTLS.
@@ -39,61 +34,131 @@ var (
 // thx to https://dev.to/living_syn/validating-client-certificate-sans-in-go-i5p
 // see example/http2/main.go for more.
 func GetTLSCfg(cfg Config, http2 ...bool) (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	raw, err := readMaterial(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := cfg.checkHash(raw); err != nil {
+		return nil, err
+	}
+
+	state, err := newServerState(cfg, raw, http2...)
 	if err != nil {
-		return nil, fmt.Errorf("cannot load cert [%s] and key [%s]: %w",
-			cfg.Cert, cfg.Key, err)
+		return nil, err
 	}
 
 	/* #nosec */
-	out := getBaseTLSCfg(&cert, http2...)
+	out := getBaseTLSCfg(state.profile, state.cert, http2...)
+	out.GetConfigForClient = state.getConfigForClient
+
 	if cfg.Insecure {
 		out.ClientAuth = tls.NoClientCert
 
 		return out, nil
 	}
 
-	lvl := cfg.Level
+	out.ClientAuth, out.ClientCAs = state.level.STD(), state.ca
+
+	return out, nil
+}
+
+// serverLevel return the level effectively enforced server side:
+// NoClientCert is upgraded to RequestClientCert.
+func serverLevel(lvl Level) Level {
 	if lvl == NoClientCert {
-		lvl = RequestClientCert
+		return RequestClientCert
 	}
 
-	out.ClientAuth = lvl.STD()
-	if e := loadCA(cfg.Ca, out); e != nil {
-		return out, e
+	return lvl
+}
+
+// rawMaterial hold the PEM content referenced by a Config, and the one of
+// its SNI entries and routes.
+type rawMaterial struct {
+	cert, key, ca []byte
+	// cas hold the content of each ca source, ca their concatenation
+	cas           [][]byte
+	intermediates []byte
+	sni           []rawMaterial
+	routes        [][]byte
+	systemRoots   bool
+}
+
+// readMaterial read the cert, key (or pkcs12), (optional) intermediates and
+// (optional) ca sources of the config and of its SNI entries.
+func readMaterial(cfg Config) (raw rawMaterial, err error) {
+	if cfg.PKCS12 != "" {
+		if err := raw.readPKCS12(cfg); err != nil {
+			return raw, err
+		}
+	} else if raw.cert, err = cfg.load(cfg.Cert); err != nil {
+		return raw, fmt.Errorf("cannot load cert [%s] and key [%s]: %w",
+			describeSource(cfg.Cert), describeSource(cfg.Key), err)
+	} else if raw.key, err = cfg.load(cfg.Key); err != nil {
+		return raw, fmt.Errorf("cannot load cert [%s] and key [%s]: %w",
+			describeSource(cfg.Cert), describeSource(cfg.Key), err)
 	}
 
-	out.GetConfigForClient = wrapGetConfigForClient(&cert, out.ClientCAs, lvl, http2...)
+	if err := raw.readIntermediates(cfg); err != nil {
+		return raw, err
+	}
 
-	return out, nil
+	if !cfg.Insecure {
+		if err := raw.readCA(cfg); err != nil {
+			return raw, err
+		}
+
+		if err := raw.readRoutesCA(cfg); err != nil {
+			return raw, err
+		}
+	}
+
+	for i := range cfg.SNI {
+		sub, err := readMaterial(cfg.sniConfig(i))
+		if err != nil {
+			return raw, fmt.Errorf("sni %s: %w", strings.Join(cfg.SNI[i].ServerNames, ","), err)
+		}
+
+		raw.sni = append(raw.sni, sub)
+	}
+
+	return raw, nil
 }
 
-func loadCA(caPath string, cfg *tls.Config) error {
-	if caPath == "" {
-		return nil
+func (raw rawMaterial) keyPair(cfg Config) (tls.Certificate, error) {
+	key, err := cfg.plainKey(raw.key)
+	if err != nil {
+		return tls.Certificate{}, err
 	}
 
-	pool := x509.NewCertPool()
+	cert, err := tls.X509KeyPair(raw.cert, key)
+	if err != nil {
+		return cert, fmt.Errorf("cannot load cert [%s] and key [%s]: %w",
+			describeSource(cfg.Cert), describeSource(cfg.Key), err)
+	}
 
-	if caCertPEM, e := os.ReadFile(caPath); e != nil {
-		return fmt.Errorf("cannot load ca cert %q in pool: %w", caPath, e)
-	} else if !pool.AppendCertsFromPEM(caCertPEM) {
-		return ErrParseUserCA
+	if err := raw.completeChain(&cert); err != nil {
+		return cert, fmt.Errorf("cert [%s]: %w", describeSource(cfg.Cert), err)
 	}
 
-	cfg.ClientCAs = pool
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return cert, fmt.Errorf("cannot parse cert [%s]: %w", describeSource(cfg.Cert), err)
+	}
 
-	return nil
+	return cert, nil
 }
 
-func getBaseTLSCfg(cert *tls.Certificate, http2 ...bool) *tls.Config {
+func getBaseTLSCfg(p tlsProfile, cert *tls.Certificate, http2 ...bool) *tls.Config {
 	cfg := &tls.Config{
-		Certificates:             []tls.Certificate{*cert},
-		PreferServerCipherSuites: true,
-		CurvePreferences:         DefaultCurve,
-		MinVersion:               tls.VersionTLS12,
-		MaxVersion:               tls.VersionTLS13, // tls.VersionTLS12 ?
-		CipherSuites:             DefaultCipher,
+		CurvePreferences: slices.Clone(p.curves),
+		MinVersion:       p.minVersion,
+		MaxVersion:       p.maxVersion,
+		CipherSuites:     slices.Clone(p.ciphers),
+	}
+
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
 	}
 
 	if len(http2) > 0 && http2[0] {
@@ -103,38 +168,173 @@ func getBaseTLSCfg(cert *tls.Certificate, http2 ...bool) *tls.Config {
 	return cfg
 }
 
-func wrapGetConfigForClient(
-	cert *tls.Certificate,
-	caCert *x509.CertPool,
-	level Level,
-	http2 ...bool,
-) func(*tls.ClientHelloInfo) (*tls.Config, error) {
-	return func(hi *tls.ClientHelloInfo) (*tls.Config, error) {
-		cfg := getBaseTLSCfg(cert, http2...)
-
-		cfg.ClientAuth, cfg.ClientCAs = level.STD(), caCert
-		if level == RequireAndVerifyClientCertAndSAN {
-			cfg.VerifyPeerCertificate = wrapVerifyPerrCertificate(caCert, hi.Conn.RemoteAddr().String())
-		}
+// serverState hold the loaded material and the settings used to build the
+// per handshake server config.
+type serverState struct {
+	cert          *tls.Certificate
+	ca            *x509.CertPool
+	intermediates []*x509.Certificate
+	policy        *Policy
+	revocation    *revocationChecker
+	certs         []CertInfo
+	profile       tlsProfile
+	sni           []sniRoute
+	routes        []routeState
+	level         Level
+	insecure      bool
+	http2         bool
+}
+
+func newServerState(cfg Config, raw rawMaterial, http2 ...bool) (*serverState, error) {
+	if err := cfg.Policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	profile, err := cfg.TLS.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := raw.keyPair(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := raw.certInfos()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Expiry.check(certs); err != nil {
+		return nil, err
+	}
+
+	state := &serverState{
+		cert:     &cert,
+		certs:    certs,
+		profile:  profile,
+		policy:   cfg.Policy,
+		level:    serverLevel(cfg.Level),
+		insecure: cfg.Insecure,
+		http2:    len(http2) > 0 && http2[0],
+	}
+
+	if state.sni, err = newSNIRoutes(cfg, raw, http2...); err != nil {
+		return nil, err
+	}
+
+	for _, route := range state.sni {
+		state.certs = append(state.certs, route.state.certs...)
+	}
+
+	if cfg.Insecure {
+		return state, nil
+	}
+
+	if state.ca, state.intermediates, err = raw.caPools(); err != nil {
+		return nil, err
+	}
+
+	if state.revocation, err = newRevocationChecker(cfg, raw, cert); err != nil {
+		return nil, err
+	}
+
+	if state.routes, err = newRouteStates(cfg, raw); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// certificate return the served certificate, with its OCSP staple if any.
+func (s *serverState) certificate() *tls.Certificate {
+	if s.revocation == nil {
+		return s.cert
+	}
+
+	return s.revocation.stapled(s.cert)
+}
+
+func (s *serverState) getConfigForClient(hi *tls.ClientHelloInfo) (*tls.Config, error) {
+	s = s.route(s.forServerName(hi.ServerName), hi)
+
+	cfg := getBaseTLSCfg(s.profile, s.certificate(), s.http2)
+	if s.insecure {
+		cfg.ClientAuth = tls.NoClientCert
 
 		return cfg, nil
 	}
+
+	cfg.ClientAuth, cfg.ClientCAs = s.level.STD(), s.ca
+	if len(s.intermediates) > 0 && verifyClient(s.level) {
+		// crypto/tls only use the presented intermediates: the chain is
+		// verified by verifyConnection, the intermediates being
+		// advertised as acceptable CAs for the clients only sending their
+		// leaf
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		if s.level == VerifyClientCertIfGiven {
+			cfg.ClientAuth = tls.RequestClientCert
+		}
+
+		if s.ca != nil {
+			cfg.ClientCAs = s.ca.Clone()
+			for _, c := range s.intermediates {
+				cfg.ClientCAs.AddCert(c)
+			}
+		}
+	}
+
+	if verifyClient(s.level) {
+		rc, _ := hi.Conn.(*recordingConn)
+		cfg.VerifyConnection = s.verifyConnection(hi.Conn.RemoteAddr().String(), rc)
+	}
+
+	return cfg, nil
 }
 
-func wrapVerifyPerrCertificate(caCert *x509.CertPool, remoteAddr string) func(
-	[][]byte, [][]*x509.Certificate) error {
-	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-		// from src/crypto/tls/handshake_server.go:680 (go 1.11) + DNSName check
-		opts := x509.VerifyOptions{
-			Roots:         caCert,
-			CurrentTime:   time.Now(),
-			Intermediates: x509.NewCertPool(),
-			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
-			DNSName:       strings.Split(remoteAddr, ":")[0],
+// verifyConnection return the VerifyConnection callback running the chain,
+// revocation and policy checks. Unlike VerifyPeerCertificate it's also run
+// on session resumption, the chains verified when the session ticket was
+// issued (ie. by another route) being verified again. The accepted chains
+// are recorded in the connection, if it's a recordingConn.
+func (s *serverState) verifyConnection(remoteAddr string, rc *recordingConn) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		verifiedChains := cs.VerifiedChains
+		if (cs.DidResume || len(s.intermediates) > 0) && len(cs.PeerCertificates) > 0 {
+			chains, err := verifyChain(rawCertificates(cs.PeerCertificates), s.ca, s.intermediates,
+				x509.VerifyOptions{KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+			if err != nil {
+				return err
+			}
+
+			verifiedChains = chains
+		}
+
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			if s.level == RequireAndVerifyClientCertAndSAN {
+				return ErrNoPeerCertificate
+			}
+
+			// no (verified) certificate, which is allowed by the level
+			return nil
 		}
 
-		_, err := verifiedChains[0][0].Verify(opts)
+		if err := s.revocation.check(verifiedChains[0]); err != nil {
+			return err
+		}
 
-		return err
+		// the chain is verified (with its intermediates) by crypto/tls or
+		// by verifyChain
+		if s.level == RequireAndVerifyClientCertAndSAN {
+			if err := s.policy.Authorize(verifiedChains[0][0], remoteAddr); err != nil {
+				return err
+			}
+		}
+
+		if rc != nil {
+			rc.record(verifiedChains)
+		}
+
+		return nil
 	}
 }
@@ -0,0 +1,333 @@
+package mtls
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+var (
+	// ErrMissingSource error is returned if only one of the Cert and Key
+	// is set.
+	ErrMissingSource = errors.New("missing source")
+
+	// ErrParseCert error is returned if a source doesn't hold any PEM
+	// certificate.
+	ErrParseCert = errors.New("no PEM certificate")
+
+	// ErrKeyMismatch error is returned if the Key doesn't match the Cert
+	// public key.
+	ErrKeyMismatch = errors.New("private key does not match the certificate")
+
+	// ErrCertChain error is returned if the Cert doesn't chain to the Ca.
+	ErrCertChain = errors.New("certificate does not chain to the CA")
+
+	// ErrLevelWithoutCA error is returned if the Level verify the client
+	// certificates while no Ca is set.
+	ErrLevelWithoutCA = errors.New("level verify the client certificates without CA")
+)
+
+// FieldError report an invalid Config field.
+type FieldError struct {
+	// Field is the json name of the field, ie. `sni[0].key`.
+	Field string
+	Err   error
+}
+
+func (e FieldError) Error() string { return e.Field + ": " + e.Err.Error() }
+
+func (e FieldError) Unwrap() error { return e.Err }
+
+// Validate check the sources load and parse, the key match the cert, the
+// cert chain to the Ca, the material match the Hash (if any) and the Level
+// is compatible with the Ca. The settings (policy, tls options, SNI
+// entries, routes and network) are checked too. Each problem is reported
+// as a FieldError, joined via errors.Join.
+//
+// The Cert and Key may both be empty (client config without certificate).
+func (cfg Config) Validate() error {
+	errs := cfg.validateMaterial("")
+
+	if _, ok := _lvl2str[cfg.Level]; !ok {
+		errs = append(errs, FieldError{"level", LevelError{cfg.Level.String()}})
+	} else if !cfg.Insecure && !cfg.hasCA() && verifyClient(cfg.Level) {
+		errs = append(errs, FieldError{"level", fmt.Errorf("%w: %s", ErrLevelWithoutCA, cfg.Level)})
+	}
+
+	if _, err := newHash(cfg.HashAlgorithm); err != nil {
+		errs = append(errs, FieldError{"hash_algorithm", err})
+	} else if cfg.Hash != "" {
+		if raw, err := readMaterial(cfg); err == nil {
+			if _, err := cfg.checkHash(raw); err != nil {
+				errs = append(errs, FieldError{"hash", err})
+			}
+		}
+	}
+
+	if err := cfg.Policy.Validate(); err != nil {
+		errs = append(errs, FieldError{"policy", err})
+	}
+
+	if err := cfg.TLS.Validate(); err != nil {
+		errs = append(errs, FieldError{"tls", err})
+	}
+
+	switch cfg.Network {
+	case "", NetworkTCP, NetworkTCP4, NetworkTCP6, NetworkUnix:
+	default:
+		errs = append(errs, FieldError{"network", fmt.Errorf("%w: %q", ErrNetwork, cfg.Network)})
+	}
+
+	for i, entry := range cfg.SNI {
+		prefix := fmt.Sprintf("sni[%d].", i)
+
+		if len(entry.ServerNames) == 0 {
+			errs = append(errs, FieldError{prefix + "server_names", ErrSNIEntry})
+		}
+
+		if entry.Cert == "" || entry.Key == "" {
+			errs = append(errs, FieldError{prefix + "cert", fmt.Errorf("%w: cert and key are required", ErrMissingSource)})
+
+			continue
+		}
+
+		errs = append(errs, cfg.sniConfig(i).validateMaterial(prefix)...)
+	}
+
+	for i, route := range cfg.Routes {
+		prefix := fmt.Sprintf("routes[%d].", i)
+
+		if err := route.Validate(); err != nil {
+			errs = append(errs, FieldError{prefix[:len(prefix)-1], err})
+		}
+
+		if route.Ca != "" {
+			if _, err := cfg.loadCAPool(route.Ca); err != nil {
+				errs = append(errs, FieldError{prefix + "ca", err})
+			}
+		} else if !cfg.Insecure && !cfg.hasCA() && route.Level != nil && verifyClient(*route.Level) {
+			errs = append(errs, FieldError{prefix + "level", fmt.Errorf("%w: %s", ErrLevelWithoutCA, *route.Level)})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateMaterial check the cert, key (or pkcs12) and ca sources, the fields being
+// prefixed by prefix.
+func (cfg Config) validateMaterial(prefix string) []error {
+	var (
+		errs          []error
+		chain         []*x509.Certificate
+		intermediates []*x509.Certificate
+		key           crypto.Signer
+		roots         *x509.CertPool
+		err           error
+	)
+
+	if cfg.Intermediates != "" {
+		if intermediates, err = cfg.loadCertificates(cfg.Intermediates); err != nil {
+			errs = append(errs, FieldError{prefix + "intermediates", fmt.Errorf("%w: %w", ErrChain, err)})
+		}
+	}
+
+	if !cfg.Insecure {
+		roots, intermediates, errs = cfg.validateCA(prefix, intermediates, errs)
+	}
+
+	switch {
+	case cfg.PKCS12 != "" && (cfg.Cert != "" || cfg.Key != ""):
+		return append(errs, FieldError{prefix + "pkcs12", fmt.Errorf("%w: pkcs12 and cert / key are exclusive", ErrPKCS12)})
+	case cfg.PKCS12 != "":
+		if chain, key, err = cfg.loadPKCS12(); err != nil {
+			return append(errs, FieldError{prefix + "pkcs12", err})
+		}
+	case cfg.Cert == "" && cfg.Key == "":
+		return errs
+	case cfg.Cert == "":
+		return append(errs, FieldError{prefix + "cert", fmt.Errorf("%w: key without cert", ErrMissingSource)})
+	case cfg.Key == "":
+		return append(errs, FieldError{prefix + "key", fmt.Errorf("%w: cert without key", ErrMissingSource)})
+	default:
+		if chain, err = cfg.loadCertificates(cfg.Cert); err != nil {
+			errs = append(errs, FieldError{prefix + "cert", err})
+		}
+
+		if key, err = cfg.loadPrivateKey(cfg.Key); err != nil {
+			errs = append(errs, FieldError{prefix + "key", err})
+		}
+	}
+
+	if len(chain) == 0 {
+		return errs
+	}
+
+	if pub, ok := chain[0].PublicKey.(interface{ Equal(x crypto.PublicKey) bool }); key != nil &&
+		(!ok || !pub.Equal(key.Public())) {
+		errs = append(errs, FieldError{prefix + "key", ErrKeyMismatch})
+	}
+
+	if _, err := orderChain(chain); err != nil {
+		errs = append(errs, FieldError{prefix + "cert", err})
+	}
+
+	if roots == nil {
+		return errs
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	for _, c := range slices.Concat(chain[1:], intermediates) {
+		opts.Intermediates.AddCert(c)
+	}
+
+	if _, err := chain[0].Verify(opts); err != nil {
+		errs = append(errs, FieldError{prefix + "cert", fmt.Errorf("%w: %w", ErrCertChain, err)})
+	}
+
+	return errs
+}
+
+// validateCA load the roots (merged with the system pool if enabled) and
+// the intermediates of the ca sources, the fields being prefixed by
+// prefix.
+func (cfg Config) validateCA(prefix string, intermediates []*x509.Certificate, errs []error,
+) (*x509.CertPool, []*x509.Certificate, []error) {
+	var roots *x509.CertPool
+
+	if cfg.SystemRoots {
+		pool, err := systemPool()
+		if err != nil {
+			return nil, intermediates, append(errs, FieldError{prefix + "system_roots", err})
+		}
+
+		roots = pool
+	}
+
+	fields := make([]string, 0, len(cfg.CAs)+1)
+	if cfg.Ca != "" {
+		fields = append(fields, prefix+"ca")
+	}
+
+	for i := range cfg.CAs {
+		fields = append(fields, fmt.Sprintf("%scas[%d].source", prefix, i))
+	}
+
+	for i, src := range cfg.caSources() {
+		certs, err := cfg.loadCertificates(src.Source)
+		if err != nil {
+			errs = append(errs, FieldError{fields[i], fmt.Errorf("%w: %w", ErrParseUserCA, err)})
+
+			continue
+		}
+
+		rootCerts, caIntermediates := splitCABundle(certs)
+		intermediates = append(caIntermediates, intermediates...)
+
+		if roots == nil {
+			roots = x509.NewCertPool()
+		}
+
+		for _, c := range rootCerts {
+			roots.AddCert(c)
+		}
+	}
+
+	return roots, intermediates, errs
+}
+
+// loadCertificates load and parse the PEM certificates referenced by ref.
+func (cfg Config) loadCertificates(ref string) ([]*x509.Certificate, error) {
+	b, err := cfg.load(ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load [%s]: %w", describeSource(ref), err)
+	}
+
+	var out []*x509.Certificate
+
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse [%s]: %w", describeSource(ref), err)
+		}
+
+		out = append(out, c)
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w in [%s]", ErrParseCert, describeSource(ref))
+	}
+
+	return out, nil
+}
+
+// loadCAPool load the PEM certificates referenced by ref in a pool.
+func (cfg Config) loadCAPool(ref string) (*x509.CertPool, error) {
+	certs, err := cfg.loadCertificates(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParseUserCA, err)
+	}
+
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c)
+	}
+
+	return pool, nil
+}
+
+// verifyClient return true if the level verify the client certificates.
+func verifyClient(lvl Level) bool {
+	return lvl >= VerifyClientCertIfGiven
+}
+
+// Diff return the json names of the fields differing between cfg and in.
+// The Observer, Passphrase, Loader and Revocation Logger aren't compared.
+func (cfg Config) Diff(in Config) []string {
+	var out []string
+
+	for _, f := range []struct {
+		name string
+		same bool
+	}{
+		{"hash", cfg.Hash == in.Hash},
+		{"hash_algorithm", cfg.HashAlgorithm == in.HashAlgorithm},
+		{"cert", cfg.Cert == in.Cert},
+		{"key", cfg.Key == in.Key},
+		{"key_passphrase", cfg.KeyPassphrase == in.KeyPassphrase},
+		{"pkcs12", cfg.PKCS12 == in.PKCS12},
+		{"ca", cfg.Ca == in.Ca},
+		{"cas", slices.Equal(cfg.CAs, in.CAs)},
+		{"system_roots", cfg.SystemRoots == in.SystemRoots},
+		{"intermediates", cfg.Intermediates == in.Intermediates},
+		{"level", cfg.Level == in.Level},
+		{"insecure", cfg.Insecure == in.Insecure},
+		{"server_name", cfg.ServerName == in.ServerName},
+		{"policy", reflect.DeepEqual(cfg.Policy, in.Policy)},
+		{"revocation", reflect.DeepEqual(cfg.Revocation.withoutLogger(), in.Revocation.withoutLogger())},
+		{"sni", reflect.DeepEqual(cfg.SNI, in.SNI)},
+		{"routes", reflect.DeepEqual(cfg.Routes, in.Routes)},
+		{"tls", reflect.DeepEqual(cfg.TLS, in.TLS)},
+		{"expiry", cfg.Expiry.same(in.Expiry)},
+		{"network", cfg.Network == in.Network},
+		{"handshake_timeout", cfg.HandshakeTimeout == in.HandshakeTimeout},
+	} {
+		if !f.same {
+			out = append(out, f.name)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,114 @@
+package mtls
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/burgesQ/gommon/mtls/generate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	requirer := require.New(t)
+
+	var (
+		pki            = newTestPKI(t)
+		server, client = pki.configs(t)
+	)
+
+	t.Log("valid configs")
+	{
+		requirer.Nil(server.Validate())
+		requirer.Nil(client.Validate())
+		requirer.Nil(Config{Ca: server.Ca}.Validate())
+	}
+
+	t.Log("missing file")
+	{
+		cfg := server
+		cfg.Cert = filepath.Join(t.TempDir(), "missing.crt")
+
+		e := cfg.Validate()
+		requirer.NotNil(e)
+		requirer.Contains(e.Error(), "cert: cannot load")
+	}
+
+	t.Log("key mismatch and foreign CA are all reported")
+	{
+		other, e := generate.NewCA(generate.WithCommonName("other-ca"))
+		requirer.Nil(e)
+
+		cfg := server
+		_, cfg.Key = pemOf(t, pki.client)
+		cfg.Ca = string(other.CertPEM())
+
+		e = cfg.Validate()
+		requirer.NotNil(e)
+		requirer.True(errors.Is(e, ErrKeyMismatch))
+		requirer.True(errors.Is(e, ErrCertChain))
+
+		var fe FieldError
+
+		requirer.True(errors.As(e, &fe))
+		requirer.Equal("key", fe.Field)
+	}
+
+	t.Log("level without CA")
+	{
+		cfg := server
+		cfg.Ca = ""
+
+		requirer.True(errors.Is(cfg.Validate(), ErrLevelWithoutCA))
+
+		cfg.Level = RequestClientCert
+		requirer.Nil(cfg.Validate())
+
+		cfg.Level = Level(42)
+		requirer.True(errors.As(cfg.Validate(), &LevelError{}))
+	}
+
+	t.Log("nested settings")
+	{
+		cfg := server
+		cfg.Network = "udp"
+		cfg.SNI = []SNIEntry{{Cert: server.Cert}}
+		cfg.Routes = []Route{{Level: ptrLevel(RequireAnyClientCert)}}
+
+		e := cfg.Validate()
+		requirer.True(errors.Is(e, ErrNetwork))
+		requirer.True(errors.Is(e, ErrSNIEntry))
+		requirer.True(errors.Is(e, ErrMissingSource))
+		requirer.True(errors.Is(e, ErrRoute))
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	requirer := require.New(t)
+
+	server, _ := newTestPKI(t).configs(t)
+
+	t.Log("same config")
+	{
+		requirer.Empty(server.Diff(server))
+		requirer.True(server.SameAs(server))
+	}
+
+	t.Log("changed fields")
+	{
+		other := server
+		other.Insecure = true
+		other.Level = RequireAndVerifyClientCertAndSAN
+		other.Policy = &Policy{CN: []string{"admin"}}
+
+		requirer.Equal([]string{"level", "insecure", "policy"}, server.Diff(other))
+		requirer.False(server.SameAs(other))
+	}
+
+	t.Log("empty config")
+	{
+		requirer.True(Config{}.Empty())
+		requirer.False(Config{Ca: server.Ca}.Empty())
+		requirer.False(Config{Level: RequireAndVerifyClientCert}.Empty())
+	}
+}